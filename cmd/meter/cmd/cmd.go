@@ -31,8 +31,11 @@ policies, either expressed or implied, of Nimbix, Inc.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -40,16 +43,27 @@ import (
 	"jarvice.io/dragen/config"
 	"jarvice.io/dragen/internal/google"
 	"jarvice.io/dragen/internal/monitor"
+	"jarvice.io/dragen/internal/secrets"
 )
 
 var (
-	bflag    bool
-	apiHost  string
-	username string
-	apikey   string
-	jobId    string
-	service  string
-	rootCmd  = &cobra.Command{
+	bflag          bool
+	apiHost        string
+	username       string
+	apikey         string
+	usernameSecret string
+	apikeySecret   string
+	jobId          string
+	jobIdAttribute string
+	service        string
+
+	secretManager secrets.Resolver
+
+	jobIdPollInterval = 10 * time.Second
+
+	monitorConfig = monitor.DefaultConfig()
+
+	rootCmd = &cobra.Command{
 		Use:   "meter",
 		Short: "A meter service for Dragen JARVICE job.",
 		Long:  `A meter service for Dragen JARVICE job.`,
@@ -61,10 +75,29 @@ var (
 			return
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			username, err := credential(ctx, username, usernameSecret, "JARVICE_API_USER")
+			if err != nil {
+				return err
+			}
+			apikey, err := credential(ctx, apikey, apikeySecret, "JARVICE_API_KEY")
+			if err != nil {
+				return err
+			}
+			if len(jobId) == 0 {
+				if len(jobIdAttribute) == 0 {
+					return errors.New("missing --job-id or --job-id-attribute")
+				}
+				// set by the batch service once the job has started
+				slog.Info("waiting for the JARVICE job ID in metadata attribute " + jobIdAttribute)
+				if jobId, err = google.WaitForAttribute(ctx, jobIdAttribute, jobIdPollInterval); err != nil {
+					return err
+				}
+			}
 			if meter, err := dragen.NewDragenMeter(apiHost, username, apikey, jobId, service); err != nil {
 				return err
 			} else {
-				if err := monitor.StartMonitor(meter); err != nil {
+				if err := monitor.StartMonitor(meter, monitorConfig); err != nil {
 					return err
 				}
 			}
@@ -74,6 +107,24 @@ var (
 	}
 )
 
+// credential resolves secret with Secret Manager. Without a secret it
+// falls back to value and then to the env environment variable.
+func credential(ctx context.Context, value, secret, env string) (string, error) {
+	if len(secret) > 0 {
+		if secretManager == nil {
+			resolver, err := secrets.NewSecretManager(ctx)
+			if err != nil {
+				return "", err
+			}
+			secretManager = resolver
+		}
+		return secretManager.Resolve(ctx, secret)
+	} else if len(value) > 0 {
+		return value, nil
+	}
+	return secrets.Env{}.Resolve(ctx, env)
+}
+
 func DeleteHost() {
 	if vm, err := google.NewGoogleCompute(); err == nil {
 		slog.Warn("Google virtual machine hosting meter service being removed")
@@ -88,13 +139,14 @@ func Execute() error {
 }
 func init() {
 	rootCmd.Flags().StringVar(&apiHost, "api-host", config.JarviceApi, "JARVICE API URL")
-	rootCmd.Flags().StringVar(&username, "username", "", "JARVICE API username")
-	rootCmd.Flags().StringVar(&apikey, "apikey", "", "JARVICE apikey")
+	rootCmd.Flags().StringVar(&username, "username", "", "JARVICE API username (default $JARVICE_API_USER)")
+	rootCmd.Flags().StringVar(&apikey, "apikey", "", "JARVICE apikey (default $JARVICE_API_KEY)")
+	rootCmd.Flags().StringVar(&usernameSecret, "username-secret", "", "Secret Manager secret version with the JARVICE API username")
+	rootCmd.Flags().StringVar(&apikeySecret, "apikey-secret", "", "Secret Manager secret version with the JARVICE apikey")
 	rootCmd.Flags().StringVar(&jobId, "job-id", "", "JARVICE job ID")
+	rootCmd.Flags().StringVar(&jobIdAttribute, "job-id-attribute", "", "instance metadata attribute to read the JARVICE job ID from when --job-id is not set")
 	rootCmd.Flags().BoolVar(&bflag, "build", false, "Build info")
-	rootCmd.Flags().StringVar(&service, "service-name", "", "Google Batch service")
-	rootCmd.MarkFlagRequired("username")
-	rootCmd.MarkFlagRequired("apikey")
-	rootCmd.MarkFlagRequired("job-id")
-	rootCmd.MarkFlagRequired("service-name")
+	rootCmd.Flags().StringVar(&service, "service-name", "", "Google Batch service VM watched by the meter (empty outside Google Compute Engine)")
+	rootCmd.Flags().IntVar(&monitorConfig.MaxPollErrors, "max-poll-errors", monitor.DefaultMaxPollErrors, "consecutive job status failures tolerated (0 for no limit)")
+	rootCmd.Flags().DurationVar(&monitorConfig.MaxPollErrorTime, "max-poll-error-time", monitor.DefaultMaxPollErrorTime, "duration of job status failures tolerated (0 for no limit)")
 }
@@ -31,22 +31,24 @@ policies, either expressed or implied, of Nimbix, Inc.
 package dragen
 
 import (
+	"context"
 	"errors"
 
 	"jarvice.io/dragen/internal/google"
 	"jarvice.io/dragen/internal/jobs"
 	"jarvice.io/dragen/internal/logger"
+	"jarvice.io/dragen/internal/monitor"
 )
 
 type DragenMeter struct {
 	job         *jobs.JarviceJob
-	vm          *google.GoogleCompute
+	vm          google.ComputeProvider
 	ServiceName string
 }
 
 func NewDragenMeter(apiHost, username, apikey, jobId, serviceName string) (*DragenMeter, error) {
 	job := jobs.NewJarviceJob(apiHost, username, apikey, jobId)
-	if !job.CheckAuth() {
+	if !job.CheckAuth(context.Background()) {
 		return nil, errors.New("invalid JARVICE configuration")
 	}
 	if vm, err := google.NewGoogleCompute(); err != nil {
@@ -60,29 +62,63 @@ func NewDragenMeter(apiHost, username, apikey, jobId, serviceName string) (*Drag
 	}
 }
 
-func (meter DragenMeter) Init() bool {
-	return google.CheckDragenLicense()
+func (meter DragenMeter) Init(ctx context.Context) (monitor.State, error) {
+	if !google.CheckDragenLicense() {
+		return monitor.StateFailed, errors.New("unable to verify DRAGEN license")
+	}
+	return monitor.StateRunning, nil
 }
 
-func (meter DragenMeter) Running() bool {
-	return meter.job.Running() && meter.vm.InstanceExist(meter.ServiceName)
+func (meter DragenMeter) Running(ctx context.Context) (monitor.State, error) {
+	state, _, err := meter.job.Status(ctx)
+	if err != nil {
+		return monitor.StateUnknown, err
+	} else if state.Terminal() {
+		return monitor.FromJobState(state), nil
+	}
+	if len(meter.ServiceName) == 0 {
+		// started from outside Google Compute Engine
+		return monitor.FromJobState(state), nil
+	}
+	if exists, err := meter.vm.InstanceExistWithError(meter.ServiceName); err != nil {
+		return monitor.StateUnknown, err
+	} else if !exists {
+		logger.Ologger.Warn("Google Batch service " + meter.ServiceName + " no longer exists")
+		return monitor.StateCanceled, nil
+	}
+	return monitor.FromJobState(state), nil
 }
 
-func (meter DragenMeter) Cleanup() {
-	meter.job.Terminate()
-	if meter.vm != nil {
-		name := meter.vm.GetName()
-		meter.vm.DeleteReservationWait(name, false)
-		meter.vm.DeleteTemplateWait(name, false)
-		meter.vm.DeleteHost()
-	} else {
+func (meter DragenMeter) Cleanup(ctx context.Context) error {
+	errs := []error{}
+	if err := meter.job.TerminateAndWait(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if meter.vm == nil {
 		logger.Elogger.Error("cannot remove Google Cloud objects. Please verify removal of the template, reservation, and vm for this job")
+		return errors.Join(append(errs, errors.New("missing Google Cloud client"))...)
+	}
+	name := meter.vm.GetName()
+	if err := meter.vm.DeleteReservationWait(name, false); err != nil && !google.IsNotFound(err) {
+		errs = append(errs, err)
+	}
+	if err := meter.vm.DeleteTemplateWait(name, false); err != nil && !google.IsNotFound(err) {
+		errs = append(errs, err)
 	}
+	if err := meter.vm.DeleteHost(); err != nil && !google.IsNotFound(err) {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
 }
 
-func (meter DragenMeter) Output() {
+func (meter DragenMeter) Output(ctx context.Context) (int, error) {
+	return 0, nil
 }
 
-func (meter DragenMeter) ExitSuccess() bool {
-	return meter.job.ExitSuccess()
+func (meter DragenMeter) ExitSuccess(ctx context.Context) (monitor.State, error) {
+	state, _, err := meter.job.Status(ctx)
+	if err != nil {
+		return monitor.StateUnknown, err
+	}
+	return monitor.FromJobState(state), nil
 }
@@ -0,0 +1,104 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package dragen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"jarvice.io/dragen/internal/google"
+	"jarvice.io/dragen/internal/jobs"
+	"jarvice.io/dragen/internal/monitor"
+)
+
+func meterServer(state string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jarvice/status" {
+			json.NewEncoder(w).Encode(jobs.JobStatusList{"555": jobs.JobStatus{Status: state}})
+		}
+	}))
+}
+
+// fakeMeterVM is the meter VM named after its template and reservation,
+// next to the batch service VM
+func fakeMeterVM() *google.FakeCompute {
+	vm := google.NewFakeCompute("dragen-abc")
+	vm.CreateInstanceTemplates("dragen-abc", "sa", google.DefaultTemplateSpec(), "meter", "/usr/local/bin/entrypoint")
+	vm.CreateReservation("dragen-abc", "dragen-abc")
+	vm.Instances["batch-vm"] = google.FakeInstance{}
+	return vm
+}
+
+func TestRunning(t *testing.T) {
+	ts := meterServer("PROCESSING STARTING")
+	defer ts.Close()
+	vm := fakeMeterVM()
+	meter := DragenMeter{job: jobs.NewJarviceJob(ts.URL, "jarvice", "abc123", "555"), vm: vm, ServiceName: "batch-vm"}
+	if state, err := meter.Running(context.Background()); err != nil || state != monitor.StateRunning {
+		t.Errorf("Running() returned %s, %v", state, err)
+	}
+	// the batch service went away without cleaning up
+	delete(vm.Instances, "batch-vm")
+	if state, err := meter.Running(context.Background()); err != nil || state != monitor.StateCanceled {
+		t.Errorf("Running() returned %s, %v", state, err)
+	}
+	vm.Fail["InstanceExistWithError"] = errors.New("compute unavailable")
+	if state, err := meter.Running(context.Background()); err == nil || state != monitor.StateUnknown {
+		t.Errorf("Running() returned %s, %v", state, err)
+	}
+	// started from outside Google Compute Engine
+	meter.ServiceName = ""
+	if state, err := meter.Running(context.Background()); err != nil || state != monitor.StateRunning {
+		t.Errorf("Running() without service returned %s, %v", state, err)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	ts := meterServer("COMPLETED")
+	defer ts.Close()
+	vm := fakeMeterVM()
+	// the batch service already removed the reservation
+	vm.DeleteReservation("dragen-abc")
+	meter := DragenMeter{job: jobs.NewJarviceJob(ts.URL, "jarvice", "abc123", "555"), vm: vm, ServiceName: "batch-vm"}
+	if err := meter.Cleanup(context.Background()); err != nil {
+		t.Fatalf("Cleanup() failed: %s", err.Error())
+	}
+	if _, ok := vm.Instances["dragen-abc"]; ok {
+		t.Error("Cleanup() did not delete the meter VM")
+	}
+	if leaks := vm.Leaks(); len(leaks) != 1 || leaks[0] != "instance/batch-vm" {
+		t.Errorf("Cleanup() left %v", leaks)
+	}
+}
@@ -31,18 +31,24 @@ policies, either expressed or implied, of Nimbix, Inc.
 package batch
 
 import (
+	"context"
 	"crypto/rand"
-	"encoding/base64"
 	"errors"
 	"fmt"
+	"maps"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"jarvice.io/dragen/cmd/service/jarvice"
 	"jarvice.io/dragen/config"
+	"jarvice.io/dragen/internal/dragenargs"
 	"jarvice.io/dragen/internal/google"
 	"jarvice.io/dragen/internal/jobs"
 	"jarvice.io/dragen/internal/logger"
+	"jarvice.io/dragen/internal/monitor"
+	"jarvice.io/dragen/internal/s3"
 )
 
 func randomString(length int) string {
@@ -53,153 +59,543 @@ func randomString(length int) string {
 
 const vmBaseName = "dragen"
 
-func cleanup(label string) {
-	if vm, err := google.NewGoogleCompute(); err == nil {
-		logger.Ologger.Warn("Google Compute Engine objects being removed for " + label)
-		vm.DeleteInstanceWait(label, false)
-		vm.DeleteReservation(label)
-		vm.DeleteTemplate(label)
-	} else {
-		logger.Elogger.Error("unable to remove Google Compute Engine object (template, reservation, and vm) for " + label)
-	}
-}
+// queuePollInterval is the job status interval while waiting for the job to
+// start, shortened in tests
+var queuePollInterval = 15 * time.Second
+
+// logUploadTimeout limits the upload of the job output during cleanup
+var logUploadTimeout = 5 * time.Minute
+
+const (
+	LogFormatPlain      = "plain"
+	LogFormatStructured = "structured"
+)
+
+// labels added to the user labels of the GCE objects and the JARVICE job
+const (
+	labelVersion  = "jarvice-dragen-version"
+	labelBatchJob = "batch-job"
+	labelSample   = "sample"
+	labelJob      = "jarvice-job"
+)
+
+var (
+	ErrQueueTimeout = errors.New("JARVICE job queue timeout")
+	ErrJobEnded     = errors.New("JARVICE job ended before starting")
+	ErrInvalidArgs  = errors.New("invalid arguments")
+)
 
 type DragenBatch struct {
-	label                              string
-	serviceAccount                     string
-	vm                                 *google.GoogleCompute
-	job                                *jobs.JarviceJob
-	b64Args                            string
-	app                                string
-	apiHost, username, apikey, machine string
-	priority                           string
+	label                     string
+	serviceAccount            string
+	vm                        google.ComputeProvider
+	client                    *jobs.Client
+	job                       *jobs.JarviceJob
+	args                      []string
+	illuminaLic               string
+	s3AccessKey, s3SecretKey  string
+	app                       string
+	username, apikey, machine string
+	priority                  string
+
+	// Google Compute Engine objects to remove during cleanup
+	template, reservation, instance bool
+
+	// QueueTimeout limits how long the JARVICE job may wait to start (0 for no limit)
+	QueueTimeout time.Duration
+	// QueueErrors limits the consecutive job status failures while the job
+	// waits to start
+	QueueErrors monitor.ErrorBudget
+	// LogFormat selects plain or structured job output on stdout
+	LogFormat string
+	// LogFile also appends the job output to a local file
+	LogFile string
+	// UsernameSecret and ApikeySecret name the Secret Manager secret versions
+	// holding the JARVICE credentials for the meter VM and its shutdown
+	// script. Without them the meter VM gets the credentials as arguments
+	// and in its shutdown script.
+	UsernameSecret, ApikeySecret string
+	logFile                      *os.File
+
+	// HMACServiceAccount switches from the s3 keys passed to NewDragenBatch
+	// to an HMAC key created for this service account and deleted during
+	// cleanup
+	HMACServiceAccount string
+	hmac               google.HMACKeyManager
+	hmacKey            string
+
+	// TemplateSpec shapes the meter VM
+	TemplateSpec google.TemplateSpec
+	// BatchJob names the Google Batch job running the service in the labels
+	BatchJob string
+	// sample is the DRAGEN --RGSM sample ID for the labels
+	sample string
+
+	// S3Endpoint is the S3 compatible endpoint for the preflight checks and
+	// the log upload, also passed to DRAGEN when it is not Cloud Storage
+	S3Endpoint string
+	// SkipPreflight starts the job without checking the DRAGEN arguments,
+	// the inputs and the output directory
+	SkipPreflight bool
+	inputs        []dragenargs.Input
+	// invalidArgs is the result of validating the DRAGEN arguments
+	invalidArgs error
+	// gs:// URIs were rewritten, DRAGEN needs the Cloud Storage endpoint
+	translated  bool
+	argEndpoint string
+
+	// complete job output, uploaded to outputDirectory after the job ends
+	s3              *s3.Client
+	outputDirectory string
+	capture         *os.File
+}
+
+// waitForStart polls job until it is processing. Status failures are
+// tolerated within budget. It returns early when ctx is cancelled, timeout
+// passes, or the job ends before starting.
+func waitForStart(ctx context.Context, job *jobs.JarviceJob, interval, timeout time.Duration,
+	budget monitor.ErrorBudget) (jobs.JobState, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	state := jobs.StateUnknown
+	for {
+		current, _, err := job.Status(ctx)
+		if errors.Is(err, jobs.ErrJobNotFound) || (err != nil && ctx.Err() != nil) {
+			return state, err
+		} else if err != nil {
+			if !budget.Fail(time.Now()) {
+				return state, fmt.Errorf("job %s status unavailable after %d attempts: %w", job.Number, budget.Count(), err)
+			}
+			logger.Ologger.Warn("queued job status poll failed", "attempt", budget.Count(), "error", err.Error())
+		} else {
+			budget.Reset()
+			state = current
+			if state == jobs.StateProcessingStarting {
+				return state, nil
+			} else if state.Terminal() {
+				return state, fmt.Errorf("%w: job %s %s", ErrJobEnded, job.Number, state)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return state, ctx.Err()
+		case <-deadline:
+			return state, fmt.Errorf("%w: job %s still %s after %s", ErrQueueTimeout, job.Number, state, timeout)
+		case <-ticker.C:
+		}
+	}
 }
 
-func NewDragenBatch(apiHost, username, apikey, app, machine,
+// NewDragenBatch prepares a DRAGEN run. A nil vm discovers the project, zone
+// and network of the calling VM from the metadata server.
+func NewDragenBatch(client *jobs.Client, vm google.ComputeProvider, username, apikey, app, machine,
 	s3AccessKey, s3SecretKey, illuminaLic string,
 	serviceAccount, priority string, args ...string) (*DragenBatch, error) {
 	if len(args) < 1 {
-		return nil, errors.New("missing Dragen arguments")
+		return nil, fmt.Errorf("%w: missing Dragen arguments", ErrInvalidArgs)
 	}
 
 	dragenBatch := DragenBatch{}
+	dragenBatch.setArgs(args)
 	dragenBatch.label = vmBaseName + "-" + randomString(12)
-	dargs := []string{}
-	if len(s3AccessKey) < 1 {
-		return nil, errors.New("missing --s3-access-key")
-	} else {
-		dargs = append(dargs, "--s3-access-key")
-		dargs = append(dargs, s3AccessKey)
-	}
-	if len(s3SecretKey) < 1 {
-		return nil, errors.New("missing --s3-secret-key")
-	} else {
-		dargs = append(dargs, "--s3-secret-key")
-		dargs = append(dargs, s3SecretKey)
+	dragenBatch.TemplateSpec = google.DefaultTemplateSpec()
+	dragenBatch.QueueErrors = monitor.ErrorBudget{
+		MaxErrors: monitor.DefaultMaxPollErrors,
+		MaxTime:   monitor.DefaultMaxPollErrorTime,
 	}
-	dargs = append(dargs, args...)
-	if len(illuminaLic) > 0 {
-		dargs = append(dargs, "--lic-server")
-		dargs = append(dargs, illuminaLic)
-	}
-
-	dragenBatch.b64Args = base64.RawStdEncoding.EncodeToString([]byte(strings.Join(dargs, " ")))
-	if vm, err := google.NewGoogleCompute(); err != nil {
-		return nil, err
-	} else {
-		dragenBatch.vm = vm
+	dragenBatch.illuminaLic = illuminaLic
+	dragenBatch.s3AccessKey = s3AccessKey
+	dragenBatch.s3SecretKey = s3SecretKey
+	if vm == nil {
+		if compute, err := google.NewGoogleCompute(); err != nil {
+			return nil, err
+		} else {
+			vm = compute
+		}
 	}
+	dragenBatch.vm = vm
 
 	dragenBatch.serviceAccount = serviceAccount
-	dragenBatch.apiHost = apiHost
+	dragenBatch.client = client
 	dragenBatch.username = username
 	dragenBatch.apikey = apikey
 	dragenBatch.app = app
 	dragenBatch.machine = machine
-	dragenBatch.job = &jobs.JarviceJob{}
 	dragenBatch.priority = priority
 	return &dragenBatch, nil
 }
 
-func (b DragenBatch) Init() bool {
+// setArgs rewrites gs:// URIs for the DRAGEN S3 helper and parses the DRAGEN
+// arguments. Parse and workflow errors are returned by Init unless
+// SkipPreflight is set.
+func (b *DragenBatch) setArgs(args []string) {
+	args, mappings := dragenargs.TranslateGS(args)
+	for _, mapping := range mappings {
+		logger.Ologger.Info("translated Cloud Storage URI", "option", mapping.Option,
+			"from", mapping.From, "to", mapping.To)
+	}
+	parsed, err := dragenargs.Parse(args)
+	if err == nil {
+		err = parsed.Validate()
+	}
+	b.args = args
+	b.invalidArgs = err
+	b.outputDirectory = parsed.OutputDirectory
+	b.inputs = parsed.Inputs()
+	b.translated = len(mappings) > 0
+	b.argEndpoint, _ = parsed.Get("--s3-endpoint")
+	b.sample = parsed.RGSM
+}
+
+// labels returns the TemplateSpec labels with the service version, the
+// Batch job and the sample ID
+func (b *DragenBatch) labels() map[string]string {
+	labels := maps.Clone(b.TemplateSpec.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for key, value := range map[string]string{
+		labelVersion:  config.Version,
+		labelBatchJob: b.BatchJob,
+		labelSample:   b.sample,
+	} {
+		if len(value) > 0 {
+			labels[key] = google.LabelValue(value)
+		}
+	}
+	return labels
+}
+
+// endpoint returns S3Endpoint, the --s3-endpoint DRAGEN argument or the
+// Cloud Storage default
+func (b *DragenBatch) endpoint() string {
+	if len(b.S3Endpoint) > 0 {
+		return b.S3Endpoint
+	} else if len(b.argEndpoint) > 0 {
+		return b.argEndpoint
+	}
+	return config.S3Endpoint
+}
+
+// s3Credentials creates the per-job HMAC key when HMACServiceAccount is set
+// and otherwise checks the s3 keys passed to NewDragenBatch
+func (b *DragenBatch) s3Credentials(ctx context.Context) error {
+	if len(b.HMACServiceAccount) > 0 {
+		if b.hmac == nil {
+			hmac, err := google.NewStorageHMAC(ctx, b.vm.GetProject())
+			if err != nil {
+				return err
+			}
+			b.hmac = hmac
+		}
+		key, err := b.hmac.CreateHMACKey(ctx, b.HMACServiceAccount)
+		if err != nil {
+			return err
+		}
+		b.hmacKey = key.AccessID
+		b.s3AccessKey = key.AccessID
+		b.s3SecretKey = key.Secret
+	} else if len(b.s3AccessKey) < 1 {
+		return fmt.Errorf("%w: missing --s3-access-key", ErrInvalidArgs)
+	} else if len(b.s3SecretKey) < 1 {
+		return fmt.Errorf("%w: missing --s3-secret-key", ErrInvalidArgs)
+	}
+	b.s3 = s3.NewClient(b.endpoint(), b.s3AccessKey, b.s3SecretKey)
+	return nil
+}
+
+// dragenArgs returns the DRAGEN arguments with the s3 credentials and
+// license server
+func (b *DragenBatch) dragenArgs() []string {
+	dargs := []string{
+		"--s3-access-key", b.s3AccessKey,
+		"--s3-secret-key", b.s3SecretKey,
+	}
+	if endpoint := b.endpoint(); len(b.argEndpoint) == 0 && (b.translated || endpoint != config.S3Endpoint) {
+		dargs = append(dargs, "--s3-endpoint", endpoint)
+	}
+	dargs = append(dargs, b.args...)
+	if len(b.illuminaLic) > 0 {
+		dargs = append(dargs, "--lic-server")
+		dargs = append(dargs, b.illuminaLic)
+	}
+	return dargs
+}
+
+func (b *DragenBatch) Init(ctx context.Context) (monitor.State, error) {
+
+	if b.invalidArgs != nil && !b.SkipPreflight {
+		return monitor.StateFailed, fmt.Errorf("%w: %w", ErrInvalidArgs, b.invalidArgs)
+	}
+	if err := b.s3Credentials(ctx); err != nil {
+		return monitor.StateFailed, err
+	}
+	if !b.SkipPreflight {
+		if err := b.preflight(ctx); err != nil {
+			return monitor.StateFailed, err
+		}
+	}
+
+	if len(b.LogFile) > 0 {
+		if f, err := os.OpenFile(b.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+			return monitor.StateFailed, err
+		} else {
+			b.logFile = f
+		}
+	}
+	if len(b.outputDirectory) > 0 {
+		if f, err := os.CreateTemp("", "jarvice-dragen-*.log"); err != nil {
+			return monitor.StateFailed, err
+		} else {
+			b.capture = f
+		}
+	}
 
-	if err := b.vm.CreateInstanceTemplates(b.label, b.serviceAccount,
+	meterArgs := []string{"--api-host", b.client.ApiHost}
+	if b.hasSecrets() {
+		meterArgs = append(meterArgs, "--username-secret", b.UsernameSecret, "--apikey-secret", b.ApikeySecret)
+	} else {
+		logger.Ologger.Warn("no JARVICE credential secrets, the meter VM metadata holds the credentials")
+		meterArgs = append(meterArgs, "--username", b.username, "--apikey", b.apikey)
+	}
+	// outside Google Compute Engine there is no service VM for the meter to
+	// watch or to identify the job, so the meter VM is created before the
+	// job is submitted and reads the job ID from its metadata
+	standalone := len(b.vm.GetName()) == 0
+	if standalone {
+		meterArgs = append(meterArgs, "--job-id-attribute", config.MeterJobAttribute)
+	} else {
+		meterArgs = append(meterArgs, "--job-id", "TEMP_JOB_ID", "--service-name", b.vm.GetName())
+	}
+	// instances inherit the template labels, reservations have none
+	labels := b.labels()
+	spec := b.TemplateSpec
+	spec.Labels = labels
+	if err := b.vm.CreateInstanceTemplates(b.label, b.serviceAccount, spec,
 		config.MeterContainer+":"+config.Version,
 		"/usr/local/bin/entrypoint",
-		"--api-host", b.apiHost,
-		"--username", b.username,
-		"--apikey", b.apikey,
-		"--job-id", "TEMP_JOB_ID",
-		"--service-name", b.vm.GetName(),
+		meterArgs...,
 	); err != nil {
-		logger.Ologger.Warn(err.Error())
-		cleanup(b.label)
-		return false
+		return monitor.StateFailed, err
 	}
+	b.template = true
 
 	if err := b.vm.CreateReservation(b.label, b.label); err != nil {
-		logger.Ologger.Warn(err.Error())
-		cleanup(b.label)
-		return false
-	}
-	if number, err := jarvice.SubmitJarviceJob(b.app, b.machine,
-		b.vm.GetId(), b.vm.GetProject(), b.vm.GetZone(),
-		b.apiHost, b.username, b.apikey, b.priority, b.b64Args); err != nil {
-		logger.Ologger.Warn(err.Error())
-		cleanup(b.label)
-		return false
-	} else {
-		*b.job = *(jobs.NewJarviceJob(b.apiHost, b.username, b.apikey, number))
+		return monitor.StateFailed, err
 	}
-	for {
-		running, err := b.job.RunningWithError()
-		if err != nil {
-			logger.Ologger.Warn(err.Error())
-			cleanup(b.label)
-			return false
+	b.reservation = true
+
+	vmid := b.vm.GetId()
+	if standalone {
+		if err := b.vm.CreateInstanceWithJobId(b.label, b.label, b.label, "", ""); err != nil {
+			return monitor.StateFailed, err
 		}
-		if running {
-			break
+		b.instance = true
+		if id, err := b.vm.GetInstanceId(b.label); err != nil {
+			return monitor.StateFailed, err
+		} else {
+			vmid = id
 		}
-		// wait
-		time.Sleep(15 * time.Second)
 	}
-	if err := b.vm.CreateInstanceWithJobId(b.label, b.label,
-		b.label, b.job.Number, b.job.GoogleShutdownScript()); err != nil {
-		logger.Ologger.Warn(err.Error())
-		cleanup(b.label)
 
-		return false
+	if number, err := jarvice.SubmitJarviceJob(ctx, b.client, b.app, b.machine,
+		vmid, b.vm.GetProject(), b.vm.GetZone(),
+		b.username, b.apikey, b.priority, labels, b.dragenArgs()); err != nil {
+		return monitor.StateFailed, err
+	} else {
+		b.job = jobs.NewJarviceJobWithClient(b.client, b.username, b.apikey, number)
+		b.job.SetLogSinks(b.logSinks()...)
+	}
+	if state, err := waitForStart(ctx, b.job, queuePollInterval, b.QueueTimeout, b.QueueErrors); err != nil {
+		return monitor.FromJobState(state), err
+	}
+	var shutdownScript string
+	var err error
+	if b.hasSecrets() {
+		shutdownScript, err = b.job.GoogleShutdownScript(b.UsernameSecret, b.ApikeySecret)
+	} else {
+		shutdownScript, err = b.job.GoogleCredentialsShutdownScript()
+	}
+	if err != nil {
+		return monitor.StateFailed, err
+	}
+	if standalone {
+		if err := b.vm.SetInstanceMetadata(b.label, map[string]string{
+			"shutdown-script":        shutdownScript,
+			config.MeterJobAttribute: b.job.Number,
+		}); err != nil {
+			return monitor.StateFailed, err
+		}
+	} else {
+		if err := b.vm.CreateInstanceWithJobId(b.label, b.label,
+			b.label, b.job.Number, shutdownScript); err != nil {
+			return monitor.StateFailed, err
+		}
+		b.instance = true
+	}
+	// the job is already running, a missing label only affects cost reports
+	if err := b.vm.SetInstanceLabels(b.label, map[string]string{labelJob: b.job.Number}); err != nil {
+		logger.Elogger.Error("unable to label " + b.label + " with the JARVICE job: " + err.Error())
 	}
 	logger.Ologger.Info("Batch processing starting")
 
-	return true
+	return monitor.StateRunning, nil
+}
+
+// hasSecrets reports whether the JARVICE credentials are in Secret Manager
+func (b *DragenBatch) hasSecrets() bool {
+	return len(b.UsernameSecret) > 0 && len(b.ApikeySecret) > 0
+}
+
+func (b *DragenBatch) logSinks() []jobs.LineSink {
+	sinks := []jobs.LineSink{}
+	if b.LogFormat == LogFormatStructured {
+		sinks = append(sinks, jobs.LoggerSink(logger.Ologger, b.job.Number))
+	} else {
+		sinks = append(sinks, jobs.WriterSink(os.Stdout))
+	}
+	if b.logFile != nil {
+		sinks = append(sinks, jobs.WriterSink(b.logFile))
+	}
+	if b.capture != nil {
+		sinks = append(sinks, jobs.WriterSink(b.capture))
+	}
+	return sinks
+}
+
+// uploadLog stores the captured job output as
+// <output-directory>/jarvice-<job>.log next to the DRAGEN results, giving up
+// after logUploadTimeout
+func (b *DragenBatch) uploadLog(ctx context.Context) error {
+	bucket, prefix, err := s3.ParseURI(b.outputDirectory)
+	if err != nil {
+		return err
+	}
+	key := "jarvice-" + b.job.Number + ".log"
+	if prefix = strings.Trim(prefix, "/"); len(prefix) > 0 {
+		key = prefix + "/" + key
+	}
+	f, err := os.Open(b.capture.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ctx, cancel := context.WithTimeout(ctx, logUploadTimeout)
+	defer cancel()
+	if err := b.s3.PutObjectReader(ctx, bucket, key, f); err != nil {
+		return err
+	}
+	logger.Ologger.Info("uploaded job output", "uri", "s3://"+bucket+"/"+key)
+	return nil
 }
 
-func (b DragenBatch) Running() bool {
+func (b *DragenBatch) Running(ctx context.Context) (monitor.State, error) {
 	// TODO: add check for MP VM
-	return b.job.Running()
+	state, _, err := b.job.Status(ctx)
+	if err != nil {
+		return monitor.StateUnknown, err
+	}
+	return monitor.FromJobState(state), nil
 }
 
-func (b DragenBatch) Cleanup() {
+func (b *DragenBatch) Cleanup(ctx context.Context) error {
 	logger.Ologger.Info("running cleanup")
+	errs := []error{}
 	if b.job != nil {
-		b.job.Terminate()
+		// output printed since the last poll
+		if _, err := b.job.GetJobOutput(ctx); err != nil {
+			logger.Elogger.Error(err.Error())
+		}
+		b.job.FlushJobOutput()
+		if err := b.job.TerminateAndWait(ctx); err != nil {
+			logger.Elogger.Error(err.Error())
+			errs = append(errs, err)
+		}
+	}
+	gceErrs := len(errs)
+	if b.instance {
+		if err := b.vm.DeleteInstanceWait(b.label, false); err != nil && !google.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	if b.reservation {
+		if err := b.vm.DeleteReservation(b.label); err != nil && !google.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	if b.template {
+		if err := b.vm.DeleteTemplate(b.label); err != nil && !google.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > gceErrs {
+		logger.Elogger.Error("unable to remove Google Compute Engine objects (template, reservation, and vm) for " + b.label)
+	}
+	// uploaded after the Google Compute Engine objects are gone and before
+	// the HMAC key it is signed with is deleted
+	if b.capture != nil {
+		// a missing log does not invalidate the DRAGEN results
+		if b.job != nil {
+			if err := b.uploadLog(ctx); err != nil {
+				logger.Elogger.Error("unable to upload job output", "error", err.Error())
+			}
+		}
+		b.capture.Close()
+		os.Remove(b.capture.Name())
 	}
-	if b.vm != nil {
-		b.vm.DeleteInstanceWait(b.label, false)
-		b.vm.DeleteReservation(b.label)
-		b.vm.DeleteTemplate(b.label)
+	if len(b.hmacKey) > 0 {
+		if err := b.hmac.DeleteHMACKey(ctx, b.hmacKey); err != nil {
+			logger.Elogger.Error("unable to delete HMAC key " + b.hmacKey)
+			errs = append(errs, err)
+		}
+	}
+	if b.logFile != nil {
+		if err := b.logFile.Close(); err != nil {
+			errs = append(errs, err)
+		}
 	}
+	return errors.Join(errs...)
 }
 
-func (b DragenBatch) Output() {
-	b.job.GetJobOutput()
+func (b *DragenBatch) Output(ctx context.Context) (int, error) {
+	return b.job.GetJobOutput(ctx)
 }
 
-func (b DragenBatch) ExitSuccess() bool {
-	if b.job != nil {
-		return b.job.ExitSuccess()
+// ExitSuccess reports the final job state. A DRAGEN failure is returned as a
+// *monitor.ApplicationError carrying its exit code.
+func (b *DragenBatch) ExitSuccess(ctx context.Context) (monitor.State, error) {
+	if b.job == nil {
+		return monitor.StateUnknown, errors.New("JARVICE job not submitted")
+	}
+	info, err := b.job.Info(ctx)
+	if err != nil {
+		return monitor.StateUnknown, err
+	}
+	exitCode := "unknown"
+	if info.ExitCode != nil {
+		exitCode = strconv.Itoa(*info.ExitCode)
+	}
+	logger.Ologger.Info("JARVICE job finished", "job", info.Number,
+		"status", info.State.String(), "exit-code", exitCode,
+		"start", formatTime(info.StartTime), "end", formatTime(info.EndTime),
+		"runtime", info.Runtime.String())
+
+	state := monitor.FromJobState(info.State)
+	if state == monitor.StateFailed && info.ExitCode != nil && *info.ExitCode != 0 {
+		return state, &monitor.ApplicationError{ExitCode: *info.ExitCode}
+	}
+	return state, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
 	}
-	return false
+	return t.UTC().Format(time.RFC3339)
 }
@@ -0,0 +1,255 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package batch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"jarvice.io/dragen/internal/dragenargs"
+	"jarvice.io/dragen/internal/google"
+	"jarvice.io/dragen/internal/jobs"
+	"jarvice.io/dragen/internal/monitor"
+	"jarvice.io/dragen/internal/s3"
+)
+
+const testNumber = "555"
+
+// fakeJob returns the submitted job of jarvice, without client retries
+func fakeJob(t *testing.T, jarvice *fakeJarvice) *jobs.JarviceJob {
+	ts := httptest.NewServer(jarvice)
+	t.Cleanup(ts.Close)
+	client := jobs.NewClient(ts.URL, nil)
+	client.Retries = 0
+	return jobs.NewJarviceJobWithClient(client, "jarvice", "abc123", testNumber)
+}
+
+func testJob(ts *httptest.Server) *jobs.JarviceJob {
+	return jobs.NewJarviceJob(ts.URL, "jarvice", "abc123", testNumber)
+}
+
+func TestWaitForStart(t *testing.T) {
+	job := fakeJob(t, &fakeJarvice{states: []string{"SUBMITTED", "SUBMITTED", "PROCESSING STARTING"}})
+	state, err := waitForStart(context.Background(), job, time.Millisecond, 0, monitor.ErrorBudget{})
+	if err != nil || state != jobs.StateProcessingStarting {
+		t.Errorf("waitForStart() failed: %s %v", state, err)
+	}
+}
+
+func TestWaitForStartQueueTimeout(t *testing.T) {
+	job := fakeJob(t, &fakeJarvice{states: []string{"SUBMITTED"}})
+	_, err := waitForStart(context.Background(), job, time.Millisecond, 20*time.Millisecond, monitor.ErrorBudget{})
+	if !errors.Is(err, ErrQueueTimeout) {
+		t.Errorf("expected queue timeout, got %v", err)
+	}
+}
+
+func TestWaitForStartCanceled(t *testing.T) {
+	job := fakeJob(t, &fakeJarvice{states: []string{"SUBMITTED"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	_, err := waitForStart(ctx, job, time.Hour, 0, monitor.ErrorBudget{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("waitForStart() did not honor cancellation")
+	}
+}
+
+func TestWaitForStartJobEnded(t *testing.T) {
+	job := fakeJob(t, &fakeJarvice{states: []string{"SUBMITTED", "CANCELED"}})
+	state, err := waitForStart(context.Background(), job, time.Millisecond, 0, monitor.ErrorBudget{})
+	if err == nil || state != jobs.StateCanceled {
+		t.Errorf("expected canceled job error, got %s %v", state, err)
+	}
+}
+
+func TestWaitForStartStatusErrors(t *testing.T) {
+	job := fakeJob(t, &fakeJarvice{states: []string{"PROCESSING STARTING"}, statusErrors: 3})
+	state, err := waitForStart(context.Background(), job, time.Millisecond, 0, monitor.ErrorBudget{MaxErrors: 3})
+	if err != nil || state != jobs.StateProcessingStarting {
+		t.Errorf("waitForStart() failed within the error budget: %s %v", state, err)
+	}
+
+	job = fakeJob(t, &fakeJarvice{states: []string{"PROCESSING STARTING"}, statusErrors: 3})
+	_, err = waitForStart(context.Background(), job, time.Millisecond, 0, monitor.ErrorBudget{MaxErrors: 2})
+	var apiErr *jobs.APIError
+	if !errors.As(err, &apiErr) || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("expected exhausted error budget, got %v", err)
+	}
+}
+
+func TestUploadLog(t *testing.T) {
+	var path, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		blob, _ := io.ReadAll(r.Body)
+		body = string(blob)
+	}))
+	defer ts.Close()
+	capture, err := os.CreateTemp(t.TempDir(), "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer capture.Close()
+	capture.WriteString("dragen finished\n")
+
+	b := &DragenBatch{
+		job:             testJob(ts),
+		s3:              s3.NewClient(ts.URL, "access", "secret"),
+		outputDirectory: "s3://bucket/results/",
+		capture:         capture,
+	}
+	if err := b.uploadLog(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/bucket/results/jarvice-"+testNumber+".log" {
+		t.Errorf("uploadLog() wrote %s", path)
+	}
+	if body != "dragen finished\n" {
+		t.Errorf("uploadLog() uploaded %q", body)
+	}
+}
+
+func TestUploadLogTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { logUploadTimeout = timeout }(logUploadTimeout)
+	logUploadTimeout = 10 * time.Millisecond
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+	capture, err := os.CreateTemp(t.TempDir(), "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer capture.Close()
+
+	b := &DragenBatch{
+		job:             testJob(ts),
+		s3:              s3.NewClient(ts.URL, "access", "secret"),
+		outputDirectory: "s3://bucket/results/",
+		capture:         capture,
+	}
+	if err := b.uploadLog(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected upload timeout, got %v", err)
+	}
+}
+
+func TestS3Credentials(t *testing.T) {
+	b := &DragenBatch{args: []string{"-f"}, illuminaLic: "lic"}
+	if err := b.s3Credentials(context.Background()); !errors.Is(err, ErrInvalidArgs) {
+		t.Errorf("expected invalid arguments, got %v", err)
+	}
+	b.s3AccessKey, b.s3SecretKey = "GOOG1EXAMPLE", "secret"
+	if err := b.s3Credentials(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected := "--s3-access-key GOOG1EXAMPLE --s3-secret-key secret -f --lic-server lic"
+	if args := strings.Join(b.dragenArgs(), " "); args != expected {
+		t.Errorf("dragenArgs() returned %q", args)
+	}
+}
+
+func TestS3CredentialsHMAC(t *testing.T) {
+	fake := &google.FakeHMAC{}
+	b := &DragenBatch{
+		args:               []string{"-f"},
+		s3AccessKey:        "GOOG1LONGLIVED",
+		s3SecretKey:        "long-lived",
+		HMACServiceAccount: "dragen@project.iam.gserviceaccount.com",
+		hmac:               fake,
+	}
+	if err := b.s3Credentials(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Keys) != 1 || fake.Keys[b.hmacKey] != b.HMACServiceAccount {
+		t.Fatalf("HMAC key not created: %v", fake.Keys)
+	}
+	args := strings.Join(b.dragenArgs(), " ")
+	if strings.Contains(args, "long-lived") || !strings.HasPrefix(args, "--s3-access-key "+b.hmacKey+" ") {
+		t.Errorf("dragenArgs() returned %q", args)
+	}
+	if err := b.Cleanup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Keys) != 0 || len(fake.Deleted) != 1 {
+		t.Error("Cleanup() did not delete the HMAC key")
+	}
+}
+
+func TestSetArgs(t *testing.T) {
+	b := DragenBatch{s3AccessKey: "id", s3SecretKey: "secret"}
+	b.setArgs([]string{"-r", "gs://bucket/ref", "-b", "gs://bucket/a.bam",
+		"--output-directory", "gs://bucket/out", "--output-file-prefix", "a"})
+	if b.invalidArgs != nil {
+		t.Fatal(b.invalidArgs)
+	}
+	if b.outputDirectory != "s3://bucket/out" {
+		t.Errorf("setArgs() set output directory %q", b.outputDirectory)
+	}
+	expected := "--s3-access-key id --s3-secret-key secret --s3-endpoint https://storage.googleapis.com " +
+		"-r s3://bucket/ref -b s3://bucket/a.bam --output-directory s3://bucket/out --output-file-prefix a"
+	if got := strings.Join(b.dragenArgs(), " "); got != expected {
+		t.Errorf("dragenArgs() returned %q", got)
+	}
+
+	b = DragenBatch{}
+	b.setArgs([]string{"-r", "ref", "-b", "s3://bucket/a.bam", "--output-directory", "s3://bucket/out",
+		"--output-file-prefix", "a"})
+	if b.invalidArgs != nil {
+		t.Fatal(b.invalidArgs)
+	}
+	if strings.Contains(strings.Join(b.dragenArgs(), " "), "--s3-endpoint") {
+		t.Error("setArgs() injected an endpoint without gs:// URIs")
+	}
+	b.S3Endpoint = "http://127.0.0.1:9000"
+	if !strings.Contains(strings.Join(b.dragenArgs(), " "), "--s3-endpoint http://127.0.0.1:9000") {
+		t.Error("dragenArgs() did not pass a custom endpoint")
+	}
+	b.setArgs([]string{"-r", "gs://bucket/ref", "s3://bucket/stray"})
+	if !errors.Is(b.invalidArgs, dragenargs.ErrInvalid) || !strings.Contains(b.invalidArgs.Error(), "unexpected argument") {
+		t.Errorf("setArgs() recorded %v", b.invalidArgs)
+	}
+}
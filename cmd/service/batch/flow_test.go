@@ -0,0 +1,370 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package batch
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"jarvice.io/dragen/cmd/service/jarvice"
+	"jarvice.io/dragen/internal/google"
+	"jarvice.io/dragen/internal/jobs"
+	"jarvice.io/dragen/internal/monitor"
+	"jarvice.io/dragen/internal/s3"
+)
+
+// fakeJarvice accepts one job and reports states[i] on the ith status call
+// after submission, repeating the last state. The first statusErrors status
+// calls fail with HTTP 502. A terminate request moves a running job to
+// TERMINATED.
+type fakeJarvice struct {
+	mu           sync.Mutex
+	states       []string
+	statusErrors int
+	exitCode     string
+	calls        int
+	submitted    int
+	terminated   int
+	submission   jarvice.JobSubmission
+}
+
+func (f *fakeJarvice) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/jarvice/submit":
+		f.submitted++
+		json.NewDecoder(r.Body).Decode(&f.submission)
+		json.NewEncoder(w).Encode(map[string]any{"name": "job", "number": 555})
+	case "/jarvice/status":
+		if f.statusErrors > 0 {
+			f.statusErrors--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		state := f.states[min(f.calls, len(f.states)-1)]
+		f.calls++
+		if f.terminated > 0 {
+			state = "TERMINATED"
+		}
+		json.NewEncoder(w).Encode(jobs.JobStatusList{
+			testNumber: jobs.JobStatus{Status: state, ExitCode: json.Number(f.exitCode)},
+		})
+	case "/jarvice/tail":
+		w.Write([]byte("dragen output\n"))
+	case "/jarvice/terminate":
+		f.terminated++
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+type flow struct {
+	batch   *DragenBatch
+	jarvice *fakeJarvice
+	vm      *google.FakeCompute
+	hmac    *google.FakeHMAC
+	store   *s3.FakeStore
+}
+
+func newFlow(t *testing.T, states ...string) *flow {
+	return newFlowOn(t, google.NewFakeCompute("batch-vm"), states...)
+}
+
+func newFlowOn(t *testing.T, vm *google.FakeCompute, states ...string) *flow {
+	interval := queuePollInterval
+	queuePollInterval = time.Millisecond
+	t.Cleanup(func() { queuePollInterval = interval })
+
+	f := &flow{
+		jarvice: &fakeJarvice{states: states, exitCode: "0"},
+		vm:      vm,
+		hmac:    &google.FakeHMAC{},
+		store:   s3.NewFakeStore(),
+	}
+	f.store.Put("inputs", "ref/hash_table.cfg", []byte("cfg"))
+	f.store.Put("inputs", "sample.bam", []byte("bam"))
+	jarviceServer := httptest.NewServer(f.jarvice)
+	t.Cleanup(jarviceServer.Close)
+	storeServer := httptest.NewServer(f.store)
+	t.Cleanup(storeServer.Close)
+
+	client := jobs.NewClient(jarviceServer.URL, nil)
+	client.Retries = 0
+	f.batch = &DragenBatch{
+		label:              "dragen-0123456789ab",
+		serviceAccount:     "dragen@google-project.iam.gserviceaccount.com",
+		vm:                 f.vm,
+		client:             client,
+		app:                "illumina-dragen",
+		username:           "jarvice",
+		apikey:             "abc123",
+		machine:            "nx1",
+		priority:           "normal",
+		LogFormat:          LogFormatStructured,
+		UsernameSecret:     "projects/google-project/secrets/username/versions/1",
+		ApikeySecret:       "projects/google-project/secrets/apikey/versions/1",
+		HMACServiceAccount: "dragen@google-project.iam.gserviceaccount.com",
+		hmac:               f.hmac,
+		S3Endpoint:         storeServer.URL,
+		TemplateSpec:       google.DefaultTemplateSpec(),
+	}
+	f.batch.setArgs([]string{"-r", "s3://inputs/ref", "-b", "s3://inputs/sample.bam",
+		"--output-directory", "s3://outputs/run", "--output-file-prefix", "sample"})
+	if f.batch.invalidArgs != nil {
+		t.Fatal(f.batch.invalidArgs)
+	}
+	return f
+}
+
+func (f *flow) run() error {
+	return monitor.StartMonitor(f.batch, monitor.Config{
+		PollInterval: 5 * time.Millisecond,
+		LogInterval:  5 * time.Millisecond,
+	})
+}
+
+// checkCleanedUp fails the test when a GCE object or the HMAC key survived
+func (f *flow) checkCleanedUp(t *testing.T) {
+	if leaks := f.vm.Leaks(); len(leaks) > 0 {
+		t.Errorf("leaked %v", leaks)
+	}
+	if len(f.hmac.Keys) > 0 {
+		t.Errorf("leaked HMAC keys %v", f.hmac.Keys)
+	}
+}
+
+func TestFlowCompleted(t *testing.T) {
+	f := newFlow(t, "SUBMITTED", "PROCESSING STARTING", "PROCESSING STARTING", "COMPLETED")
+	if err := f.run(); err != nil {
+		t.Fatalf("run failed: %s", err.Error())
+	}
+	f.checkCleanedUp(t)
+	created := strings.Join(f.vm.Created, ",")
+	if created != "template/dragen-0123456789ab,reservation/dragen-0123456789ab,instance/dragen-0123456789ab" {
+		t.Errorf("created %s", created)
+	}
+	if f.jarvice.submitted != 1 || f.jarvice.terminated != 0 {
+		t.Errorf("submitted %d, terminated %d", f.jarvice.submitted, f.jarvice.terminated)
+	}
+	if log, ok := f.store.Get("outputs", "run/jarvice-555.log"); !ok || !strings.Contains(string(log), "dragen output") {
+		t.Errorf("job output not uploaded: %q", log)
+	}
+}
+
+func TestFlowInstance(t *testing.T) {
+	f := newFlow(t, "PROCESSING STARTING", "COMPLETED")
+	// keep the objects around for inspection
+	f.vm.Fail["DeleteInstance"] = errors.New("keep instance")
+	f.vm.Fail["DeleteTemplate"] = errors.New("keep template")
+	f.run()
+	instance := f.vm.Instances["dragen-0123456789ab"]
+	if instance.JobID != testNumber || instance.Template != "dragen-0123456789ab" ||
+		!strings.Contains(instance.ShutdownScript, "projects/google-project/secrets/apikey/versions/1") {
+		t.Errorf("instance %+v", instance)
+	}
+	template := strings.Join(f.vm.Templates["dragen-0123456789ab"], " ")
+	if !strings.Contains(template, "--job-id TEMP_JOB_ID --service-name batch-vm") {
+		t.Errorf("template %s", template)
+	}
+}
+
+func TestFlowWithoutSecrets(t *testing.T) {
+	f := newFlow(t, "PROCESSING STARTING", "COMPLETED")
+	f.vm.Fail["DeleteInstance"] = errors.New("keep instance")
+	f.vm.Fail["DeleteTemplate"] = errors.New("keep template")
+	f.batch.UsernameSecret, f.batch.ApikeySecret = "", ""
+	if err := f.run(); err == nil || !errors.Is(err, f.vm.Fail["DeleteInstance"]) {
+		t.Fatalf("run failed: %v", err)
+	}
+	template := strings.Join(f.vm.Templates["dragen-0123456789ab"], " ")
+	if !strings.Contains(template, "--username jarvice --apikey abc123") || strings.Contains(template, "-secret") {
+		t.Errorf("template %s", template)
+	}
+	script := f.vm.Instances["dragen-0123456789ab"].ShutdownScript
+	if !strings.Contains(script, "JOB_NUMBER='"+testNumber+"'") || !strings.Contains(script, "'abc123'") ||
+		strings.Contains(script, "secretmanager") {
+		t.Errorf("shutdown script without secrets %q", script)
+	}
+}
+
+func TestFlowStandalone(t *testing.T) {
+	f := newFlowOn(t, google.NewFakeCompute(""), "SUBMITTED", "PROCESSING STARTING", "COMPLETED")
+	// keep the objects around for inspection
+	f.vm.Fail["DeleteInstance"] = errors.New("keep instance")
+	f.vm.Fail["DeleteTemplate"] = errors.New("keep template")
+	f.run()
+	instance := f.vm.Instances["dragen-0123456789ab"]
+	if f.jarvice.submission.Application.Parameters.GcpVmid != instance.Id || len(instance.Id) == 0 {
+		t.Errorf("job submitted for VM %q, meter VM is %q", f.jarvice.submission.Application.Parameters.GcpVmid, instance.Id)
+	}
+	if instance.Metadata["jarvice-job-id"] != testNumber || !strings.Contains(instance.ShutdownScript, "JOB_NUMBER='"+testNumber+"'") {
+		t.Errorf("meter VM metadata %v, shutdown script %q", instance.Metadata, instance.ShutdownScript)
+	}
+	if created := strings.Join(f.vm.Created, ","); created != "template/dragen-0123456789ab,reservation/dragen-0123456789ab,instance/dragen-0123456789ab" {
+		t.Errorf("created %s", created)
+	}
+	template := strings.Join(f.vm.Templates["dragen-0123456789ab"], " ")
+	if !strings.Contains(template, "--job-id-attribute jarvice-job-id") || strings.Contains(template, "--service-name") {
+		t.Errorf("template %s", template)
+	}
+}
+
+func TestFlowStandaloneCleanup(t *testing.T) {
+	f := newFlowOn(t, google.NewFakeCompute(""), "PROCESSING STARTING", "COMPLETED")
+	if err := f.run(); err != nil {
+		t.Fatalf("run failed: %s", err.Error())
+	}
+	f.checkCleanedUp(t)
+}
+
+func TestFlowLabels(t *testing.T) {
+	for _, vm := range []*google.FakeCompute{google.NewFakeCompute("batch-vm"), google.NewFakeCompute("")} {
+		f := newFlowOn(t, vm, "PROCESSING STARTING", "COMPLETED")
+		f.vm.Fail["DeleteInstance"] = errors.New("keep instance")
+		f.vm.Fail["DeleteTemplate"] = errors.New("keep template")
+		f.batch.TemplateSpec.Labels = map[string]string{"cost-center": "cc1"}
+		f.batch.BatchJob = "DRAGEN-run-7"
+		f.batch.setArgs([]string{"-r", "s3://inputs/ref", "-b", "s3://inputs/sample.bam", "--RGSM", "NA12878",
+			"--output-directory", "s3://outputs/run", "--output-file-prefix", "sample"})
+		f.run()
+		labels := f.vm.Instances["dragen-0123456789ab"].Labels
+		if labels["cost-center"] != "cc1" || labels["batch-job"] != "dragen-run-7" ||
+			labels["sample"] != "na12878" || labels["jarvice-job"] != testNumber {
+			t.Errorf("meter VM labels %v", labels)
+		}
+		if label := f.jarvice.submission.JobLabel; !strings.HasSuffix(label, ",batch-job=dragen-run-7,cost-center=cc1,sample=na12878") {
+			t.Errorf("JARVICE job label %s", label)
+		}
+	}
+
+	// a job that failed to be labeled keeps running
+	f := newFlow(t, "PROCESSING STARTING", "COMPLETED")
+	f.vm.Fail["SetInstanceLabels"] = errors.New("labels failed")
+	if err := f.run(); err != nil {
+		t.Errorf("run failed: %s", err.Error())
+	}
+	f.checkCleanedUp(t)
+}
+
+func TestFlowDragenFailed(t *testing.T) {
+	f := newFlow(t, "PROCESSING STARTING", "COMPLETED WITH ERROR")
+	f.jarvice.exitCode = "3"
+	err := f.run()
+	var appErr *monitor.ApplicationError
+	if !errors.As(err, &appErr) || appErr.ExitCode != 3 {
+		t.Errorf("expected DRAGEN exit code 3, got %v", err)
+	}
+	f.checkCleanedUp(t)
+}
+
+func TestFlowInitFailures(t *testing.T) {
+	for _, method := range []string{"CreateInstanceTemplates", "CreateReservation", "CreateInstanceWithJobId"} {
+		t.Run(method, func(t *testing.T) {
+			f := newFlow(t, "PROCESSING STARTING")
+			f.vm.Fail[method] = errors.New(method + " failed")
+			err := f.run()
+			var initErr *monitor.InitError
+			if !errors.As(err, &initErr) {
+				t.Errorf("expected init error, got %v", err)
+			}
+			f.checkCleanedUp(t)
+			// a submitted job must not keep running without its meter
+			if f.jarvice.submitted > 0 && f.jarvice.terminated == 0 {
+				t.Error("submitted job not terminated")
+			}
+		})
+	}
+}
+
+func TestFlowCleanupFailure(t *testing.T) {
+	f := newFlow(t, "PROCESSING STARTING", "COMPLETED")
+	f.vm.Fail["DeleteReservation"] = errors.New("reservation in use")
+	err := f.run()
+	var cleanupErr *monitor.CleanupError
+	if !errors.As(err, &cleanupErr) {
+		t.Errorf("expected cleanup error, got %v", err)
+	}
+	if leaks := f.vm.Leaks(); strings.Join(leaks, ",") != "reservation/dragen-0123456789ab" {
+		t.Errorf("leaked %v", leaks)
+	}
+}
+
+func TestFlowPreflightFailed(t *testing.T) {
+	f := newFlow(t, "PROCESSING STARTING")
+	f.store.ReadOnly["outputs"] = true
+	if err := f.run(); !errors.Is(err, ErrPreflight) {
+		t.Errorf("expected preflight failure, got %v", err)
+	}
+	if len(f.vm.Created) > 0 || f.jarvice.submitted > 0 {
+		t.Errorf("created %v before the preflight check", f.vm.Created)
+	}
+	f.checkCleanedUp(t)
+}
+
+func TestFlowInvalidArgs(t *testing.T) {
+	f := newFlow(t, "PROCESSING STARTING")
+	f.batch.setArgs([]string{"-r", "s3://inputs/ref", "-b", "s3://inputs/sample.bam"})
+	if err := f.run(); !errors.Is(err, ErrInvalidArgs) || !strings.Contains(err.Error(), "--output-directory") {
+		t.Errorf("expected invalid arguments, got %v", err)
+	}
+	if len(f.vm.Created) > 0 || f.jarvice.submitted > 0 {
+		t.Errorf("created %v before the argument check", f.vm.Created)
+	}
+
+	f = newFlow(t, "PROCESSING STARTING", "COMPLETED")
+	f.batch.setArgs([]string{"-r", "s3://inputs/ref", "-b", "s3://inputs/sample.bam"})
+	f.batch.SkipPreflight = true
+	if err := f.run(); err != nil {
+		t.Errorf("run with --skip-preflight failed: %s", err.Error())
+	}
+
+	// arguments the parser rejects are skipped too
+	f = newFlow(t, "PROCESSING STARTING", "COMPLETED")
+	f.batch.setArgs([]string{"-r", "s3://inputs/ref", "-b", "s3://inputs/sample.bam", "stray",
+		"--output-directory", "s3://outputs/run"})
+	if err := f.run(); !errors.Is(err, ErrInvalidArgs) || !strings.Contains(err.Error(), "stray") {
+		t.Errorf("expected invalid arguments, got %v", err)
+	}
+	f = newFlow(t, "PROCESSING STARTING", "COMPLETED")
+	f.batch.setArgs([]string{"-r", "s3://inputs/ref", "-b", "s3://inputs/sample.bam", "stray",
+		"--output-directory", "s3://outputs/run"})
+	f.batch.SkipPreflight = true
+	if err := f.run(); err != nil {
+		t.Errorf("run with --skip-preflight failed: %s", err.Error())
+	}
+}
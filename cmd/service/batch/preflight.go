@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"jarvice.io/dragen/internal/logger"
+	"jarvice.io/dragen/internal/s3"
+)
+
+var ErrPreflight = errors.New("preflight check failed")
+
+// preflightProbe is the object written and deleted to check write access to
+// the output directory
+const preflightProbe = ".jarvice-preflight-"
+
+// preflight checks that every s3:// input exists and is readable with the s3
+// credentials and that the output directory is writable. Every URI is
+// checked and reported before failing. Only missing objects and denied
+// access are ErrPreflight, object store outages are returned as they are.
+func (b *DragenBatch) preflight(ctx context.Context) error {
+	errs, failed := []error{}, []error{}
+	report := func(option, uri string, err error) {
+		if err != nil {
+			logger.Elogger.Error("preflight", "option", option, "uri", uri, "error", err.Error())
+			if unavailable(err) {
+				failed = append(failed, fmt.Errorf("%s %s: %w", option, uri, err))
+			} else {
+				errs = append(errs, fmt.Errorf("%s %s: %w", option, uri, err))
+			}
+		} else {
+			logger.Ologger.Info("preflight", "option", option, "uri", uri, "result", "ok")
+		}
+	}
+	for _, input := range b.inputs {
+		if !strings.HasPrefix(input.URI, "s3://") {
+			continue
+		}
+		report(input.Option, input.URI, b.checkInput(ctx, input.URI, input.Prefix))
+	}
+	if strings.HasPrefix(b.outputDirectory, "s3://") {
+		report("--output-directory", b.outputDirectory, b.checkOutput(ctx))
+	}
+	if len(errs) > 0 {
+		failed = append(failed, fmt.Errorf("%w: %w", ErrPreflight, errors.Join(errs...)))
+	}
+	return errors.Join(failed...)
+}
+
+// unavailable reports whether err means the object store did not answer,
+// rather than that an object is missing or access is denied
+func unavailable(err error) bool {
+	var apiErr *s3.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError ||
+			apiErr.StatusCode == http.StatusRequestTimeout || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func (b *DragenBatch) checkInput(ctx context.Context, uri string, prefix bool) error {
+	bucket, key, err := s3.ParseURI(uri)
+	if err != nil {
+		return err
+	}
+	if !prefix {
+		return b.s3.HeadObject(ctx, bucket, key)
+	}
+	if key = strings.TrimSuffix(key, "/"); len(key) > 0 {
+		key += "/"
+	}
+	keys, err := b.s3.ListObjects(ctx, bucket, key, 1)
+	if err != nil {
+		return err
+	} else if len(keys) == 0 {
+		return errors.New("no objects found")
+	}
+	return nil
+}
+
+func (b *DragenBatch) checkOutput(ctx context.Context) error {
+	bucket, prefix, err := s3.ParseURI(b.outputDirectory)
+	if err != nil {
+		return err
+	}
+	key := preflightProbe + randomString(12)
+	if prefix = strings.Trim(prefix, "/"); len(prefix) > 0 {
+		key = prefix + "/" + key
+	}
+	if err := b.s3.PutObject(ctx, bucket, key, nil); err != nil {
+		return err
+	}
+	return b.s3.DeleteObject(ctx, bucket, key)
+}
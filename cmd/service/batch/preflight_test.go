@@ -0,0 +1,132 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package batch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"jarvice.io/dragen/internal/s3"
+)
+
+func preflightBatch(t *testing.T, store *s3.FakeStore, args ...string) *DragenBatch {
+	ts := httptest.NewServer(store)
+	t.Cleanup(ts.Close)
+	b := &DragenBatch{S3Endpoint: ts.URL, s3AccessKey: "id", s3SecretKey: "secret"}
+	b.setArgs(args)
+	if err := b.s3Credentials(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPreflight(t *testing.T) {
+	store := s3.NewFakeStore()
+	store.Put("refs", "hg38/hash_table.cfg", []byte("cfg"))
+	store.Put("inputs", "R1.fastq.gz", []byte("r1"))
+	store.Put("inputs", "R2.fastq.gz", []byte("r2"))
+	b := preflightBatch(t, store, "-r", "gs://refs/hg38/", "-1", "s3://inputs/R1.fastq.gz",
+		"-2", "s3://inputs/R2.fastq.gz", "--RGID", "a", "--RGSM", "a", "--enable-map-align", "true",
+		"--output-directory", "s3://outputs/run1", "--output-file-prefix", "a")
+	if err := b.preflight(context.Background()); err != nil {
+		t.Fatalf("preflight() failed: %s", err.Error())
+	}
+	for name := range store.Objects {
+		if strings.Contains(name, preflightProbe) {
+			t.Errorf("preflight() left %s", name)
+		}
+	}
+}
+
+func TestPreflightReport(t *testing.T) {
+	store := s3.NewFakeStore()
+	store.Put("refs", "hg38-old/hash_table.cfg", []byte("cfg"))
+	store.Put("inputs", "R1.fastq.gz", []byte("r1"))
+	store.ReadOnly["inputs"] = true
+	b := preflightBatch(t, store, "-r", "s3://refs/hg38", "-1", "s3://inputs/R1.fastq.gz",
+		"-2", "s3://inputs/R2.fastq.gz", "--RGID", "a", "--RGSM", "a", "--enable-map-align", "true",
+		"--output-directory", "s3://inputs/out", "--output-file-prefix", "a")
+	err := b.preflight(context.Background())
+	if !errors.Is(err, ErrPreflight) {
+		t.Fatalf("preflight() returned %v", err)
+	}
+	report := err.Error()
+	for _, expected := range []string{
+		"--ref-dir s3://refs/hg38: no objects found",
+		"--fastq-file2 s3://inputs/R2.fastq.gz: HEAD s3://inputs/R2.fastq.gz returned HTTP 404",
+		"--output-directory s3://inputs/out: PUT",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("preflight() report %q missing %q", report, expected)
+		}
+	}
+	if strings.Contains(report, "--fastq-file1") {
+		t.Errorf("preflight() reported a readable input: %q", report)
+	}
+}
+
+func TestPreflightUnavailable(t *testing.T) {
+	store := s3.NewFakeStore()
+	store.Put("inputs", "R1.fastq.gz", []byte("r1"))
+	b := preflightBatch(t, store, "-1", "s3://inputs/R1.fastq.gz", "-2", "s3://inputs/R2.fastq.gz",
+		"--output-directory", "s3://outputs/run1")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/outputs/") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		store.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	b.s3.Endpoint = ts.URL
+
+	// a missing input is still reported with the outage
+	err := b.preflight(context.Background())
+	if !errors.Is(err, ErrPreflight) || !strings.Contains(err.Error(), "returned HTTP 503") {
+		t.Errorf("preflight() returned %v", err)
+	}
+
+	store.Put("inputs", "R2.fastq.gz", []byte("r2"))
+	err = b.preflight(context.Background())
+	var apiErr *s3.APIError
+	if err == nil || errors.Is(err, ErrPreflight) || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected an unavailable object store, got %v", err)
+	}
+
+	ts.Close()
+	if err := b.preflight(context.Background()); err == nil || errors.Is(err, ErrPreflight) {
+		t.Errorf("expected a transport error, got %v", err)
+	}
+}
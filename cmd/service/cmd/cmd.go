@@ -31,13 +31,21 @@ policies, either expressed or implied, of Nimbix, Inc.
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"jarvice.io/dragen/cmd/service/batch"
 	"jarvice.io/dragen/config"
+	"jarvice.io/dragen/internal/google"
+	"jarvice.io/dragen/internal/jobs"
 	"jarvice.io/dragen/internal/logger"
 	"jarvice.io/dragen/internal/monitor"
+	"jarvice.io/dragen/internal/secrets"
 )
 
 var (
@@ -52,6 +60,30 @@ var (
 	illuminaLic    string
 	serviceAccount string
 	priority       string
+	apiTimeout     time.Duration
+	apiRetries     int
+	queueTimeout   time.Duration
+	logFormat      string
+	logFile        string
+	usernameSecret string
+	apikeySecret   string
+	hmacAccount    string
+	s3Endpoint     string
+	skipPreflight  bool
+	project        string
+	zone           string
+	network        string
+	subnet         string
+	meterSpecFile  string
+	labels         map[string]string
+	batchJob       string
+	meterSpec      = google.DefaultTemplateSpec()
+	monitorConfig  = monitor.DefaultConfig()
+
+	// newResolver resolves sm:// credential flags, replaced in tests
+	newResolver = func(ctx context.Context) (secrets.Resolver, error) {
+		return secrets.NewSecretManager(ctx)
+	}
 
 	rootCmd = &cobra.Command{
 		Use:   "service",
@@ -65,13 +97,56 @@ var (
 			return
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			dragenBatch, err := batch.NewDragenBatch(apiHost, username, apikey,
+			if len(dragenApp) == 0 {
+				return fmt.Errorf("%w: missing --dragen-app", batch.ErrInvalidArgs)
+			}
+			if logFormat != batch.LogFormatPlain && logFormat != batch.LogFormatStructured {
+				return fmt.Errorf("%w: invalid --log-format %s", batch.ErrInvalidArgs, logFormat)
+			}
+			if err := resolveSecrets(context.Background()); err != nil {
+				return err
+			}
+			// the meter VM and its shutdown script read the JARVICE
+			// credentials from Secret Manager when the secrets are known
+			if len(usernameSecret) > 0 || len(apikeySecret) > 0 {
+				for _, secret := range []string{usernameSecret, apikeySecret} {
+					if err := secrets.ValidateVersionName(secret); err != nil {
+						return fmt.Errorf("%w: --username-secret and --apikey-secret: %w", batch.ErrInvalidArgs, err)
+					}
+				}
+			}
+			vm, err := newCompute()
+			if err != nil {
+				return err
+			}
+			spec, err := templateSpec(cmd)
+			if err != nil {
+				return err
+			}
+			client := jobs.NewClient(apiHost, &http.Client{})
+			client.Timeout = apiTimeout
+			client.Retries = apiRetries
+			dragenBatch, err := batch.NewDragenBatch(client, vm, username, apikey,
 				dragenApp, machine, s3AccessKey, s3SecretKey, illuminaLic,
 				serviceAccount, priority, args...)
 			if err != nil {
 				return err
 			} else {
-				if err := monitor.StartMonitor(dragenBatch); err != nil {
+				dragenBatch.QueueTimeout = queueTimeout
+				dragenBatch.QueueErrors = monitor.ErrorBudget{
+					MaxErrors: monitorConfig.MaxPollErrors,
+					MaxTime:   monitorConfig.MaxPollErrorTime,
+				}
+				dragenBatch.LogFormat = logFormat
+				dragenBatch.LogFile = logFile
+				dragenBatch.UsernameSecret = usernameSecret
+				dragenBatch.ApikeySecret = apikeySecret
+				dragenBatch.HMACServiceAccount = hmacAccount
+				dragenBatch.S3Endpoint = s3Endpoint
+				dragenBatch.SkipPreflight = skipPreflight
+				dragenBatch.TemplateSpec = spec
+				dragenBatch.BatchJob = batchJob
+				if err := monitor.StartMonitor(dragenBatch, monitorConfig); err != nil {
 					return err
 				}
 			}
@@ -86,17 +161,137 @@ var (
 func Execute() error {
 	return rootCmd.Execute()
 }
+
+// resolveSecrets replaces sm:// references in the credential flags with the
+// secret values. JARVICE credential references also provide the secret
+// versions handed to the meter VM unless those are set explicitly.
+func resolveSecrets(ctx context.Context) error {
+	if name, ok := secrets.Reference(username); ok && len(usernameSecret) == 0 {
+		usernameSecret = name
+	}
+	if name, ok := secrets.Reference(apikey); ok && len(apikeySecret) == 0 {
+		apikeySecret = name
+	}
+	if !secrets.HasReference(username, apikey, s3AccessKey, s3SecretKey, illuminaLic) {
+		return nil
+	}
+	resolver, err := newResolver(ctx)
+	if err != nil {
+		return err
+	}
+	return secrets.ResolveReferences(ctx, resolver, &username, &apikey, &s3AccessKey, &s3SecretKey, &illuminaLic)
+}
+
+// newCompute uses the explicit --project and --zone configuration, or the
+// metadata server of the calling VM when neither is set
+func newCompute() (google.ComputeProvider, error) {
+	if len(project) == 0 && len(zone) == 0 {
+		if len(network) > 0 {
+			return nil, fmt.Errorf("%w: --network needs --project and --zone", batch.ErrInvalidArgs)
+		}
+		return nil, nil
+	}
+	vm, err := google.NewGoogleComputeWithConfig(project, zone, network, subnet)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", batch.ErrInvalidArgs, err)
+	}
+	return vm, nil
+}
+
+// templateSpec loads the meter VM template from --meter-spec and applies the
+// --meter-* flags given on the command line over it. The --label pairs are
+// added to its labels.
+func templateSpec(cmd *cobra.Command) (google.TemplateSpec, error) {
+	spec := google.DefaultTemplateSpec()
+	if len(meterSpecFile) > 0 {
+		var err error
+		if spec, err = google.LoadTemplateSpec(meterSpecFile); err != nil {
+			return spec, fmt.Errorf("%w: --meter-spec: %w", batch.ErrInvalidArgs, err)
+		}
+	}
+	flags := cmd.Flags()
+	if flags.Changed("meter-machine-type") {
+		spec.MachineType = meterSpec.MachineType
+	}
+	if flags.Changed("meter-disk-type") {
+		spec.DiskType = meterSpec.DiskType
+	}
+	if flags.Changed("meter-disk-size") {
+		spec.DiskSizeGb = meterSpec.DiskSizeGb
+	}
+	if flags.Changed("subnet") {
+		spec.Subnet = subnet
+	}
+	if flags.Changed("meter-tags") {
+		spec.Tags = meterSpec.Tags
+	}
+	if flags.Changed("meter-no-external-ip") {
+		spec.NoExternalIP = meterSpec.NoExternalIP
+	}
+	if flags.Changed("meter-kms-key") {
+		spec.KMSKey = meterSpec.KMSKey
+	}
+	if flags.Changed("meter-maintenance-policy") {
+		spec.MaintenancePolicy = meterSpec.MaintenancePolicy
+	}
+	if len(labels) > 0 {
+		spec.Labels = maps.Clone(spec.Labels)
+		if spec.Labels == nil {
+			spec.Labels = map[string]string{}
+		}
+		maps.Copy(spec.Labels, labels)
+	}
+	if err := spec.Validate(); err != nil {
+		return spec, fmt.Errorf("%w: meter VM template: %w", batch.ErrInvalidArgs, err)
+	}
+	return spec, nil
+}
+
+func usageError(cmd *cobra.Command, err error) error {
+	return fmt.Errorf("%w: %w", batch.ErrInvalidArgs, err)
+}
+
 func init() {
-	illuminaLic = os.Getenv("ILLUMINA_LIC_SERVER")
 	rootCmd.Flags().StringVar(&apiHost, "api-host", config.JarviceApi, "JARVICE API URL")
 	rootCmd.Flags().StringVar(&machine, "machine", config.JarviceMachine, "JARVICE machine type")
-	rootCmd.Flags().StringVar(&username, "username", os.Getenv("JARVICE_API_USER"), "JARVICE API username")
-	rootCmd.Flags().StringVar(&apikey, "apikey", os.Getenv("JARVICE_API_KEY"), "JARVICE apikey")
-	rootCmd.Flags().StringVar(&s3AccessKey, "s3-access-key", os.Getenv("S3_ACCESS_KEY"), "s3 access key")
-	rootCmd.Flags().StringVar(&s3SecretKey, "s3-secret-key", os.Getenv("S3_SECRET_KEY"), "s3 secret key")
-	rootCmd.Flags().StringVar(&dragenApp, "dragen-app", "", "Dragen JARVICE application")
+	rootCmd.Flags().StringVar(&username, "username", os.Getenv("JARVICE_API_USER"), "JARVICE API username or sm:// secret reference")
+	rootCmd.Flags().StringVar(&apikey, "apikey", os.Getenv("JARVICE_API_KEY"), "JARVICE apikey or sm:// secret reference")
+	rootCmd.Flags().StringVar(&s3AccessKey, "s3-access-key", os.Getenv("S3_ACCESS_KEY"), "s3 access key or sm:// secret reference")
+	rootCmd.Flags().StringVar(&s3SecretKey, "s3-secret-key", os.Getenv("S3_SECRET_KEY"), "s3 secret key or sm:// secret reference")
+	rootCmd.Flags().StringVar(&hmacAccount, "s3-hmac-sa", "", "create a per-job HMAC key for this service account instead of using the s3 keys")
+	rootCmd.Flags().StringVar(&s3Endpoint, "s3-endpoint", "", "S3 compatible endpoint (default Cloud Storage or the DRAGEN --s3-endpoint argument)")
+	rootCmd.Flags().BoolVar(&skipPreflight, "skip-preflight", false, "start the job without checking the DRAGEN arguments, inputs and output directory")
+	rootCmd.Flags().StringVar(&illuminaLic, "lic-server", os.Getenv("ILLUMINA_LIC_SERVER"), "Illumina license server or sm:// secret reference")
+	rootCmd.Flags().StringVar(&usernameSecret, "username-secret", "", "Secret Manager secret version with the JARVICE API username (default from a sm:// --username)")
+	rootCmd.Flags().StringVar(&apikeySecret, "apikey-secret", "", "Secret Manager secret version with the JARVICE apikey (default from a sm:// --apikey)")
+	rootCmd.Flags().StringVar(&dragenApp, "dragen-app", "", "Dragen JARVICE application (required)")
 	rootCmd.Flags().BoolVar(&bflag, "build", false, "Build info")
 	rootCmd.Flags().StringVar(&serviceAccount, "google-sa", "default", "Google Cloud service account")
+	rootCmd.Flags().StringVar(&project, "project", "", "Google Cloud project, skips the metadata server with --zone")
+	rootCmd.Flags().StringVar(&zone, "zone", "", "Google Compute Engine zone, skips the metadata server with --project")
+	rootCmd.Flags().StringVar(&network, "network", "", "VPC network with --project and --zone (default \"default\")")
+	rootCmd.Flags().StringVar(&subnet, "subnet", "", "meter VM subnet name or path (default first subnet of the network in the zone region)")
+	rootCmd.Flags().StringVar(&meterSpecFile, "meter-spec", "", "JSON file with the meter VM template spec, overridden by the --meter-* flags")
+	rootCmd.Flags().StringVar(&meterSpec.MachineType, "meter-machine-type", meterSpec.MachineType, "meter VM machine type")
+	rootCmd.Flags().StringVar(&meterSpec.DiskType, "meter-disk-type", meterSpec.DiskType, "meter VM disk type")
+	rootCmd.Flags().Int64Var(&meterSpec.DiskSizeGb, "meter-disk-size", meterSpec.DiskSizeGb, "meter VM data disk size in GB")
+	rootCmd.Flags().StringSliceVar(&meterSpec.Tags, "meter-tags", nil, "meter VM network tags")
+	rootCmd.Flags().BoolVar(&meterSpec.NoExternalIP, "meter-no-external-ip", false, "no external IP on the meter VM (needs Cloud NAT or Private Google Access)")
+	rootCmd.Flags().StringVar(&meterSpec.KMSKey, "meter-kms-key", "", "Cloud KMS key encrypting the meter VM disks")
+	rootCmd.Flags().StringVar(&meterSpec.MaintenancePolicy, "meter-maintenance-policy", meterSpec.MaintenancePolicy, "meter VM host maintenance policy (MIGRATE or TERMINATE)")
+	rootCmd.Flags().StringToStringVar(&labels, "label", nil, "key=value label for the Google Compute Engine objects and the JARVICE job (repeatable)")
+	rootCmd.Flags().StringVar(&batchJob, "batch-job", os.Getenv("BATCH_JOB_ID"), "Google Batch job name for the labels")
 	rootCmd.Flags().StringVar(&priority, "job-priority", "normal", "JARVICE job priority")
-	rootCmd.MarkFlagRequired("dragen-app")
+	rootCmd.Flags().DurationVar(&apiTimeout, "api-timeout", jobs.DefaultTimeout, "JARVICE API per-call timeout")
+	rootCmd.Flags().IntVar(&apiRetries, "api-retries", jobs.DefaultRetries, "JARVICE API retries for failed calls")
+	rootCmd.Flags().StringVar(&logFormat, "log-format", batch.LogFormatPlain, "job output format on stdout (plain or structured)")
+	rootCmd.Flags().StringVar(&logFile, "log-file", "", "also append job output to this file")
+	rootCmd.Flags().DurationVar(&monitorConfig.LogInterval, "log-interval", monitorConfig.LogInterval, "interval between job output refreshes")
+	rootCmd.Flags().DurationVar(&queueTimeout, "queue-timeout", 0, "maximum time the JARVICE job may stay queued (0 for no limit)")
+	rootCmd.Flags().DurationVar(&monitorConfig.MaxRuntime, "max-runtime", 0, "maximum JARVICE job runtime before it is terminated (0 for no limit)")
+	rootCmd.Flags().DurationVar(&monitorConfig.StallTimeout, "stall-timeout", 0, "warn when the job prints no new output for this long (0 to disable)")
+	rootCmd.Flags().BoolVar(&monitorConfig.StallTerminate, "stall-terminate", false, "terminate the job once --stall-timeout passes")
+	rootCmd.Flags().IntVar(&monitorConfig.MaxPollErrors, "max-poll-errors", monitor.DefaultMaxPollErrors, "consecutive job status failures tolerated (0 for no limit)")
+	rootCmd.Flags().DurationVar(&monitorConfig.MaxPollErrorTime, "max-poll-error-time", monitor.DefaultMaxPollErrorTime, "duration of job status failures tolerated (0 for no limit)")
+	rootCmd.SetFlagErrorFunc(usageError)
 }
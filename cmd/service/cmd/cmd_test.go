@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+
+	"jarvice.io/dragen/cmd/service/batch"
+	"jarvice.io/dragen/internal/google"
+	"jarvice.io/dragen/internal/secrets"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	newResolver = func(ctx context.Context) (secrets.Resolver, error) {
+		return secrets.Fake{
+			"projects/p/secrets/jarviceApiUsername/versions/latest": "jarvice",
+			"projects/p/secrets/jarviceApiKey/versions/latest":      "abc123",
+			"projects/p/secrets/illuminaLicServer/versions/1":       "https://lic.example.com",
+		}, nil
+	}
+	username = "sm://projects/p/secrets/jarviceApiUsername/versions/latest"
+	apikey = "sm://projects/p/secrets/jarviceApiKey/versions/latest"
+	apikeySecret = "projects/p/secrets/jarviceApiKey/versions/2"
+	s3AccessKey, s3SecretKey = "GOOG1EXAMPLE", "secret"
+	illuminaLic = "sm://projects/p/secrets/illuminaLicServer/versions/1"
+	if err := resolveSecrets(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if username != "jarvice" || apikey != "abc123" || illuminaLic != "https://lic.example.com" {
+		t.Errorf("resolveSecrets() resolved %q %q %q", username, apikey, illuminaLic)
+	}
+	if s3AccessKey != "GOOG1EXAMPLE" || s3SecretKey != "secret" {
+		t.Error("resolveSecrets() changed plain values")
+	}
+	if usernameSecret != "projects/p/secrets/jarviceApiUsername/versions/latest" {
+		t.Errorf("username secret %q not derived from --username", usernameSecret)
+	}
+	if apikeySecret != "projects/p/secrets/jarviceApiKey/versions/2" {
+		t.Errorf("explicit apikey secret replaced by %q", apikeySecret)
+	}
+
+	s3SecretKey = "sm://projects/p/secrets/missing/versions/1"
+	if err := resolveSecrets(context.Background()); err == nil {
+		t.Error("resolveSecrets() of a missing secret did not fail")
+	}
+}
+
+func TestNewCompute(t *testing.T) {
+	defer func() { project, zone, network, subnet = "", "", "", "" }()
+	if vm, err := newCompute(); vm != nil || err != nil {
+		t.Errorf("newCompute() without configuration returned %v, %v", vm, err)
+	}
+	network = "dragen"
+	if _, err := newCompute(); !errors.Is(err, batch.ErrInvalidArgs) {
+		t.Errorf("newCompute() accepted --network alone: %v", err)
+	}
+	project = "google-project"
+	if _, err := newCompute(); !errors.Is(err, batch.ErrInvalidArgs) {
+		t.Errorf("newCompute() accepted --project without --zone: %v", err)
+	}
+	zone = "us-central1-a"
+	vm, err := newCompute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.GetProject() != "google-project" || vm.GetZone() != "us-central1-a" || len(vm.GetName()) > 0 {
+		t.Errorf("newCompute() returned %+v", vm)
+	}
+}
+
+func TestTemplateSpec(t *testing.T) {
+	defer func() {
+		meterSpecFile, subnet, meterSpec, labels = "", "", google.DefaultTemplateSpec(), nil
+		rootCmd.Flags().VisitAll(func(flag *pflag.Flag) { flag.Changed = false })
+	}()
+	spec, err := templateSpec(rootCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.MachineType != google.DefaultTemplateSpec().MachineType || spec.NoExternalIP {
+		t.Errorf("templateSpec() without flags returned %+v", spec)
+	}
+
+	meterSpecFile = filepath.Join(t.TempDir(), "spec.json")
+	os.WriteFile(meterSpecFile, []byte(`{"machineType": "e2-small", "diskSizeGb": 20, "labels": {"team": "genomics"}}`), 0644)
+	for flag, value := range map[string]string{
+		"meter-machine-type":   "e2-medium",
+		"meter-no-external-ip": "true",
+		"meter-tags":           "allow-nat,dragen",
+		"subnet":               "projects/host/regions/us-central1/subnetworks/shared",
+		"label":                "cost-center=cc1",
+	} {
+		if err := rootCmd.Flags().Set(flag, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec, err = templateSpec(rootCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.MachineType != "e2-medium" || spec.DiskSizeGb != 20 || spec.Labels["team"] != "genomics" || spec.Labels["cost-center"] != "cc1" {
+		t.Errorf("templateSpec() did not apply the flags over the file: %+v", spec)
+	}
+	if !spec.NoExternalIP || len(spec.Tags) != 2 || spec.Subnet != subnet {
+		t.Errorf("templateSpec() returned %+v", spec)
+	}
+
+	rootCmd.Flags().Set("label", "Team=x")
+	if _, err := templateSpec(rootCmd); !errors.Is(err, batch.ErrInvalidArgs) {
+		t.Errorf("templateSpec() accepted an invalid label: %v", err)
+	}
+	labels = nil
+	rootCmd.Flags().Set("meter-maintenance-policy", "LIVE")
+	if _, err := templateSpec(rootCmd); !errors.Is(err, batch.ErrInvalidArgs) {
+		t.Errorf("templateSpec() accepted an invalid policy: %v", err)
+	}
+	meterSpecFile = filepath.Join(t.TempDir(), "missing.json")
+	if _, err := templateSpec(rootCmd); !errors.Is(err, batch.ErrInvalidArgs) {
+		t.Errorf("templateSpec() accepted a missing file: %v", err)
+	}
+}
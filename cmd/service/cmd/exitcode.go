@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"net/http"
+
+	"jarvice.io/dragen/cmd/service/batch"
+	"jarvice.io/dragen/internal/jobs"
+	"jarvice.io/dragen/internal/monitor"
+)
+
+// Service exit codes. Google Batch lifecycle policies can retry
+// ExitInfrastructure while usage and DRAGEN failures are never retried.
+// ExitCleanupFailed follows a finished job whose cleanup failed, so it must
+// not be retried either.
+const (
+	ExitSuccess        = 0
+	ExitError          = 1
+	ExitUsage          = 2
+	ExitDragenFailed   = 3
+	ExitJarviceFailed  = 4
+	ExitCanceled       = 5
+	ExitInfrastructure = 6
+	ExitTimeout        = 7
+	ExitCleanupFailed  = 8
+)
+
+// ExitCode maps the error returned by Execute to a service exit code
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	var appErr *monitor.ApplicationError
+	var apiErr *jobs.APIError
+	var initErr *monitor.InitError
+	var runtimeErr *monitor.RuntimeError
+	var cleanupErr *monitor.CleanupError
+	switch {
+	case errors.Is(err, batch.ErrInvalidArgs), errors.Is(err, batch.ErrPreflight):
+		return ExitUsage
+	case errors.Is(err, monitor.ErrTimeout), errors.Is(err, monitor.ErrStalled),
+		errors.Is(err, batch.ErrQueueTimeout):
+		return ExitTimeout
+	case errors.Is(err, monitor.ErrInterrupted):
+		return ExitCanceled
+	case errors.As(err, &appErr):
+		return ExitDragenFailed
+	case errors.As(err, &runtimeErr):
+		switch runtimeErr.State {
+		case monitor.StateCanceled:
+			return ExitCanceled
+		case monitor.StateFailed:
+			return ExitJarviceFailed
+		}
+		// job status lost
+		return ExitInfrastructure
+	case errors.As(err, &initErr):
+		if initErr.State == monitor.StateCanceled {
+			return ExitCanceled
+		}
+		// JARVICE rejected the job or failed it before it started
+		if errors.Is(err, batch.ErrJobEnded) || errors.Is(err, jobs.ErrJobNotFound) ||
+			(errors.As(err, &apiErr) && apiErr.StatusCode < http.StatusInternalServerError &&
+				apiErr.StatusCode != http.StatusTooManyRequests) {
+			return ExitJarviceFailed
+		}
+		return ExitInfrastructure
+	case errors.As(err, &cleanupErr):
+		// the job succeeded, rerunning it would not remove the objects left
+		return ExitCleanupFailed
+	}
+	return ExitError
+}
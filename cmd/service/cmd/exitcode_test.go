@@ -0,0 +1,83 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"jarvice.io/dragen/cmd/service/batch"
+	"jarvice.io/dragen/internal/jobs"
+	"jarvice.io/dragen/internal/monitor"
+	"jarvice.io/dragen/internal/s3"
+)
+
+func TestExitCode(t *testing.T) {
+	errGCE := errors.New("reservation quota exceeded")
+	cleanupErr := &monitor.CleanupError{Err: errGCE}
+	cases := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"success", nil, ExitSuccess},
+		{"bad arguments", fmt.Errorf("%w: missing --s3-access-key", batch.ErrInvalidArgs), ExitUsage},
+		{"preflight failed", errors.Join(&monitor.InitError{State: monitor.StateFailed,
+			Err: fmt.Errorf("%w: -1 s3://bucket/R1.fastq.gz: not found", batch.ErrPreflight)}, cleanupErr), ExitUsage},
+		{"preflight unavailable", &monitor.InitError{State: monitor.StateFailed,
+			Err: &s3.APIError{Method: "HEAD", Bucket: "bucket", Key: "R1.fastq.gz", StatusCode: 503}}, ExitInfrastructure},
+		{"dragen failed", errors.Join(&monitor.RuntimeError{State: monitor.StateFailed,
+			Err: &monitor.ApplicationError{ExitCode: 2}}, cleanupErr), ExitDragenFailed},
+		{"jarvice failed", &monitor.RuntimeError{State: monitor.StateFailed, Err: monitor.ErrJobFailed}, ExitJarviceFailed},
+		{"jarvice rejected", &monitor.InitError{State: monitor.StateFailed,
+			Err: &jobs.APIError{Path: "/jarvice/submit", StatusCode: 400}}, ExitJarviceFailed},
+		{"ended before start", &monitor.InitError{State: monitor.StateFailed,
+			Err: fmt.Errorf("%w: job 1 COMPLETED WITH ERROR", batch.ErrJobEnded)}, ExitJarviceFailed},
+		{"canceled", &monitor.RuntimeError{State: monitor.StateCanceled, Err: monitor.ErrJobFailed}, ExitCanceled},
+		{"interrupted", &monitor.RuntimeError{State: monitor.StateCanceled, Err: monitor.ErrInterrupted}, ExitCanceled},
+		{"init infrastructure", errors.Join(&monitor.InitError{State: monitor.StateFailed, Err: errGCE}, cleanupErr), ExitInfrastructure},
+		{"jarvice unavailable", &monitor.InitError{State: monitor.StateFailed,
+			Err: &jobs.APIError{Path: "/jarvice/submit", StatusCode: 503}}, ExitInfrastructure},
+		{"status lost", &monitor.RuntimeError{State: monitor.StateUnknown, Err: errors.New("EOF")}, ExitInfrastructure},
+		{"cleanup only", cleanupErr, ExitCleanupFailed},
+		{"max runtime", &monitor.RuntimeError{State: monitor.StateRunning, Err: monitor.ErrTimeout}, ExitTimeout},
+		{"stalled", &monitor.RuntimeError{State: monitor.StateRunning, Err: monitor.ErrStalled}, ExitTimeout},
+		{"queue timeout", &monitor.InitError{State: monitor.StatePending,
+			Err: fmt.Errorf("%w: job 1 still SUBMITTED", batch.ErrQueueTimeout)}, ExitTimeout},
+		{"other", errors.New("unexpected"), ExitError},
+	}
+	for _, c := range cases {
+		if code := ExitCode(c.err); code != c.code {
+			t.Errorf("%s: ExitCode() = %d, expected %d", c.name, code, c.code)
+		}
+	}
+}
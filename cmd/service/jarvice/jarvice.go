@@ -31,14 +31,16 @@ policies, either expressed or implied, of Nimbix, Inc.
 package jarvice
 
 import (
-	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
-	"errors"
-	"io"
-	"net/http"
+	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 
 	"jarvice.io/dragen/config"
+	"jarvice.io/dragen/internal/jobs"
 )
 
 type DragenParams struct {
@@ -86,8 +88,38 @@ type JobResponse struct {
 	Number int    `json:"number"`
 }
 
-func SubmitJarviceJob(app, machine, vmid, project, zone,
-	apiHost, username, apikey, priority, base64Args string) (string, error) {
+// QuoteArgs single-quotes every argument for a POSIX shell so that
+// eval "set -- QUOTED" restores the exact argument list
+func QuoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// command runs program with args. The quoted args are base64 encoded so the
+// submitted command only holds shell-safe characters and no credentials in
+// plain text.
+func command(program string, args []string) string {
+	b64Args := base64.StdEncoding.EncodeToString([]byte(QuoteArgs(args)))
+	return `eval "set -- $(echo ` + b64Args + ` | base64 -d)"; ` + program + ` "$@"`
+}
+
+// JobLabel lists the vmid and labels as comma separated key=value pairs,
+// sorted by key
+func JobLabel(vmid string, labels map[string]string) string {
+	pairs := []string{}
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(append([]string{"vmid=" + vmid}, pairs...), ",")
+}
+
+func SubmitJarviceJob(ctx context.Context, client *jobs.Client,
+	app, machine, vmid, project, zone,
+	username, apikey, priority string, labels map[string]string, args []string) (string, error) {
 
 	values := &JobSubmission{
 		App:     app,
@@ -96,9 +128,7 @@ func SubmitJarviceJob(app, machine, vmid, project, zone,
 			Command:  "Batch",
 			Geometry: "1,920x1,080",
 			Parameters: DragenParams{
-				Command: "MYDRAGEN_ARGS=$(echo " +
-					base64Args +
-					" | base64 -d); " + config.DragenCommand + " $MYDRAGEN_ARGS",
+				Command:      command(config.DragenCommand, args),
 				GcpVmid:      vmid,
 				GcpProjectid: project,
 				GcpZone:      zone,
@@ -119,23 +149,15 @@ func SubmitJarviceJob(app, machine, vmid, project, zone,
 		},
 		Priority: priority,
 	}
-	values.JobLabel = "vmid=" + vmid
+	values.JobLabel = JobLabel(vmid, labels)
 
-	jsonBlob, err := json.Marshal(values)
-	if err != nil {
-		return "", err
-	}
-	resp, err := http.Post(apiHost+"/jarvice/submit", "application/json", bytes.NewBuffer(jsonBlob))
+	body, err := client.PostJSON(ctx, "/jarvice/submit", values)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("JARVICE job submission failed: %w", err)
 	}
-
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return "", errors.New("JARVICE job submission failed")
-	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
 	jobResponse := JobResponse{}
-	json.Unmarshal(body, &jobResponse)
+	if err := json.Unmarshal(body, &jobResponse); err != nil {
+		return "", fmt.Errorf("JARVICE job submission failed: %w", err)
+	}
 	return strconv.Itoa(jobResponse.Number), nil
 }
@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package jarvice
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+var adversarialArgs = [][]string{
+	{"-f", "-r", "s3://bucket/4_2_reference", "--output-directory", "s3://bucket/output 2"},
+	{"it's", `"double"`, `back\slash`, `\'`, `'\''`, "'", "''"},
+	{"*", "?", "[a-z]*", "~", "~root", "{a,b}"},
+	{"$HOME", "${PATH}", "$(id)", "`id`", "$((1+1))", "!!", "$'\\n'"},
+	{"", " ", "\t", "line\nbreak", "trailing\n", "\n"},
+	{"; rm -rf /", "a && b", "a | b", "a > /tmp/x", "#comment", "--lic-server=x y"},
+	{"ümlaut", "日本語", "emoji 🧬"},
+	{},
+}
+
+// shellArgs runs command with a program printing every argument it gets
+// NUL terminated and returns the arguments
+func shellArgs(t *testing.T, shell string, args []string) []string {
+	out, err := exec.Command(shell, "-c", command(`printf '%s\0'`, args)).Output()
+	if err != nil {
+		t.Fatalf("%s failed: %v", shell, err)
+	}
+	got := strings.Split(string(out), "\x00")
+	return got[:len(got)-1]
+}
+
+func equalArgs(a, b []string) bool {
+	if len(a) == 0 && len(b) == 1 && b[0] == "" {
+		// printf '%s\0' with no arguments prints a single NUL
+		return true
+	}
+	return strings.Join(a, "\x00") == strings.Join(b, "\x00") && len(a) == len(b)
+}
+
+func TestCommandRoundTrip(t *testing.T) {
+	for _, shell := range []string{"sh", "bash", "dash"} {
+		if _, err := exec.LookPath(shell); err != nil {
+			continue
+		}
+		for _, args := range adversarialArgs {
+			if got := shellArgs(t, shell, args); !equalArgs(args, got) {
+				t.Errorf("%s: sent %q, got %q", shell, args, got)
+			}
+		}
+	}
+}
+
+func TestCommandSafeCharacters(t *testing.T) {
+	cmd := command("dragen", []string{"--s3-secret-key", "secret", "$(id)"})
+	prefix, suffix, _ := strings.Cut(cmd, " | base64 -d")
+	b64 := strings.TrimPrefix(prefix, `eval "set -- $(echo `)
+	if strings.Trim(b64, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/=") != "" {
+		t.Errorf("command() encoded %q", b64)
+	}
+	if suffix != `)"; dragen "$@"` || strings.Contains(cmd, "secret") {
+		t.Errorf("command() returned %q", cmd)
+	}
+}
+
+func FuzzCommand(f *testing.F) {
+	for _, args := range adversarialArgs {
+		f.Add(strings.Join(args, "\x01"))
+	}
+	f.Fuzz(func(t *testing.T, joined string) {
+		if strings.ContainsRune(joined, 0) {
+			// not representable in argv
+			return
+		}
+		args := strings.Split(joined, "\x01")
+		if got := shellArgs(t, "sh", args); !equalArgs(args, got) {
+			t.Errorf("sent %q, got %q", args, got)
+		}
+	})
+}
+
+func TestJobLabel(t *testing.T) {
+	if label := JobLabel("42", nil); label != "vmid=42" {
+		t.Errorf("JobLabel() returned %s", label)
+	}
+	label := JobLabel("42", map[string]string{"sample": "na12878", "cost-center": "cc1"})
+	if label != "vmid=42,cost-center=cc1,sample=na12878" {
+		t.Errorf("JobLabel() returned %s", label)
+	}
+}
@@ -40,7 +40,7 @@ import (
 func main() {
 	if err := cmd.Execute(); err != nil {
 		logger.Elogger.Error(err.Error())
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 	logger.Ologger.Info("Batch processing complete")
 }
@@ -45,4 +45,8 @@ const (
 	DragenCommand  = "/usr/bin/taskset -c $(/usr/bin/cat /sys/bus/pci/devices/$(/opt/xilinx/xrt/bin/xbutil scan | tail -5 | grep xilinx | cut -d ' ' -f3)/local_cpulist) /usr/local/bin/jarvice-dragen-s3-helper.sh"
 	JarviceApi     = "https://illumina.nimbix.net/api"
 	JarviceMachine = "nx1"
+	S3Endpoint     = "https://storage.googleapis.com"
+	// MeterJobAttribute is the meter VM metadata attribute holding the
+	// JARVICE job ID when the service runs outside Google Compute Engine
+	MeterJobAttribute = "jarvice-job-id"
 )
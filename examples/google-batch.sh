@@ -87,6 +87,8 @@ batch_json=$(cat <<EOD
                 "--dragen-app", "$JARVICE_DRAGEN_APP",
                 "--google-sa", "$SERVICE_ACCOUNT",
                 "--job-priority", "$JARVICE_JOB_PRIORITY",
+                "--username-secret", "$JARVICE_API_USERNAME_SECRET",
+                "--apikey-secret", "$JARVICE_API_APIKEY_SECRET",
                 "--"
               ],
               "volumes": []
@@ -0,0 +1,393 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package dragenargs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid DRAGEN arguments")
+
+// aliases maps short DRAGEN options to their long form
+var aliases = map[string]string{
+	"-r": "--ref-dir",
+	"-1": "--fastq-file1",
+	"-2": "--fastq-file2",
+	"-b": "--bam-input",
+	"-f": "--force",
+	"-h": "--help",
+	"-V": "--version",
+}
+
+// flags are options that never take a value
+var flags = map[string]bool{
+	"--force":   true,
+	"--help":    true,
+	"--version": true,
+}
+
+// valueOptions are the options read by the service, which need a value.
+// Other options without a value are taken as switches.
+var valueOptions = map[string]bool{
+	"--output-directory":   true,
+	"--output-file-prefix": true,
+	"--RGID":               true,
+	"--RGSM":               true,
+	"--RGID-tumor":         true,
+	"--RGSM-tumor":         true,
+	"--s3-endpoint":        true,
+}
+
+// needsValue reports whether option name must be given a value
+func needsValue(name string) bool {
+	if strings.HasPrefix(name, "--enable-") || valueOptions[name] {
+		return true
+	}
+	for _, input := range inputOptions {
+		if input == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Args is the typed view of the DRAGEN command line passed through the
+// service. Options holds every option by long name in the order given, the
+// typed fields hold the last value of repeated options.
+type Args struct {
+	Reference        string
+	Fastq1, Fastq2   string
+	FastqList        string
+	BAM, CRAM        string
+	OutputDirectory  string
+	OutputFilePrefix string
+	RGID, RGSM       string
+	// the tumor inputs of somatic runs, alone or with the normal inputs
+	TumorFastq1, TumorFastq2 string
+	TumorFastqList           string
+	TumorBAM, TumorCRAM      string
+	RGIDTumor, RGSMTumor     string
+	// Enable holds the --enable-* switches by name, without the prefix
+	Enable  map[string]bool
+	Options []Option
+}
+
+type Option struct {
+	Name  string
+	Value string
+}
+
+// Enabled reports whether --enable-name true was given
+func (a *Args) Enabled(name string) bool {
+	return a.Enable[name]
+}
+
+// Get returns the first value of option name
+func (a *Args) Get(name string) (string, bool) {
+	for _, option := range a.Options {
+		if option.Name == name {
+			return option.Value, true
+		}
+	}
+	return "", false
+}
+
+// isOption reports whether token starts a new option rather than being the
+// value of the previous one
+func isOption(token string) bool {
+	if strings.HasPrefix(token, "--") {
+		return true
+	}
+	if _, ok := aliases[token]; ok {
+		return true
+	}
+	_, err := strconv.ParseFloat(token, 64)
+	return strings.HasPrefix(token, "-") && len(token) > 1 && err != nil
+}
+
+// isValue reports whether args[i] is the value of option name rather than
+// the next option. -1 and -2 are values of options that need one, and of
+// other options when no value of their own follows, as in
+// --ht-num-threads -1.
+func isValue(name string, args []string, i int) bool {
+	if i >= len(args) {
+		return false
+	}
+	if token := args[i]; token == "-1" || token == "-2" {
+		return needsValue(name) || i+1 >= len(args) || isOption(args[i+1])
+	}
+	return !isOption(args[i])
+}
+
+// Parse reads options given as "--name value", "--name=value" or as short
+// options. The short aliases of the common options are stored by long name.
+// Options may be repeated, DRAGEN accepts several --variant for example.
+// Options the service does not read may be switches without a value.
+func Parse(args []string) (*Args, error) {
+	parsed := &Args{Enable: map[string]bool{}}
+	errs := []error{}
+	for i := 0; i < len(args); i++ {
+		token := args[i]
+		if !isOption(token) {
+			errs = append(errs, fmt.Errorf("unexpected argument %q", token))
+			continue
+		}
+		name, value, hasValue := strings.Cut(token, "=")
+		if long, ok := aliases[name]; ok {
+			name = long
+		}
+		if !strings.HasPrefix(name, "--") && len(name) > 2 {
+			errs = append(errs, fmt.Errorf("unknown option %s, long options start with --", name))
+			continue
+		}
+		if !hasValue && !flags[name] {
+			if isValue(name, args, i+1) {
+				i++
+				value = args[i]
+			} else if needsValue(name) {
+				errs = append(errs, fmt.Errorf("missing value for %s", name))
+				continue
+			}
+		}
+		parsed.Options = append(parsed.Options, Option{Name: name, Value: value})
+		if err := parsed.set(name, value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return parsed, fmt.Errorf("%w: %w", ErrInvalid, errors.Join(errs...))
+	}
+	return parsed, nil
+}
+
+func (a *Args) set(name, value string) error {
+	switch name {
+	case "--ref-dir":
+		a.Reference = value
+	case "--fastq-file1":
+		a.Fastq1 = value
+	case "--fastq-file2":
+		a.Fastq2 = value
+	case "--fastq-list":
+		a.FastqList = value
+	case "--bam-input":
+		a.BAM = value
+	case "--cram-input":
+		a.CRAM = value
+	case "--tumor-fastq1":
+		a.TumorFastq1 = value
+	case "--tumor-fastq2":
+		a.TumorFastq2 = value
+	case "--tumor-fastq-list":
+		a.TumorFastqList = value
+	case "--tumor-bam-input":
+		a.TumorBAM = value
+	case "--tumor-cram-input":
+		a.TumorCRAM = value
+	case "--output-directory":
+		a.OutputDirectory = value
+	case "--output-file-prefix":
+		a.OutputFilePrefix = value
+	case "--RGID":
+		a.RGID = value
+	case "--RGSM":
+		a.RGSM = value
+	case "--RGID-tumor":
+		a.RGIDTumor = value
+	case "--RGSM-tumor":
+		a.RGSMTumor = value
+	default:
+		if enable, ok := strings.CutPrefix(name, "--enable-"); ok {
+			switch strings.ToLower(value) {
+			case "true":
+				a.Enable[enable] = true
+			case "false":
+				a.Enable[enable] = false
+			default:
+				return fmt.Errorf("%s must be true or false, got %q", name, value)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the combinations the DRAGEN workflows need and returns
+// every problem found. Everything else, such as which inputs a workflow
+// reads, is left to DRAGEN.
+func (a *Args) Validate() error {
+	errs := []error{}
+	if _, ok := a.Get("--help"); ok {
+		return nil
+	}
+	if _, ok := a.Get("--version"); ok {
+		return nil
+	}
+	if len(a.OutputDirectory) == 0 {
+		errs = append(errs, errors.New("missing --output-directory"))
+	}
+	if value, ok := a.Get("--build-hash-table"); ok && strings.EqualFold(value, "true") {
+		if _, ok := a.Get("--ht-reference"); !ok {
+			errs = append(errs, errors.New("--build-hash-table needs --ht-reference"))
+		}
+	}
+	normal := given("-1", a.Fastq1, "--fastq-list", a.FastqList, "-b/--bam-input", a.BAM, "--cram-input", a.CRAM)
+	tumor := given("--tumor-fastq1", a.TumorFastq1, "--tumor-fastq-list", a.TumorFastqList,
+		"--tumor-bam-input", a.TumorBAM, "--tumor-cram-input", a.TumorCRAM)
+	for _, inputs := range [][]string{normal, tumor} {
+		if len(inputs) > 1 {
+			errs = append(errs, errors.New("conflicting inputs "+strings.Join(inputs, ", ")))
+		}
+	}
+	if len(a.Fastq2) > 0 && len(a.Fastq1) == 0 {
+		errs = append(errs, errors.New("-2 needs -1"))
+	}
+	if len(a.TumorFastq2) > 0 && len(a.TumorFastq1) == 0 {
+		errs = append(errs, errors.New("--tumor-fastq2 needs --tumor-fastq1"))
+	}
+	if a.Enabled("map-align") {
+		if len(a.Reference) == 0 {
+			errs = append(errs, errors.New("--enable-map-align needs reference -r/--ref-dir"))
+		}
+		if len(normal) == 0 && len(tumor) == 0 {
+			errs = append(errs, errors.New("--enable-map-align needs reads: -1/-2, --fastq-list, -b/--bam-input, --cram-input or their --tumor-* forms"))
+		}
+		// read groups come from the FASTQ list or the BAM/CRAM headers otherwise
+		if len(a.Fastq1) > 0 {
+			if len(a.RGID) == 0 {
+				errs = append(errs, errors.New("--enable-map-align with -1 needs --RGID"))
+			}
+			if len(a.RGSM) == 0 {
+				errs = append(errs, errors.New("--enable-map-align with -1 needs --RGSM"))
+			}
+		}
+		if len(a.TumorFastq1) > 0 {
+			if len(a.RGIDTumor) == 0 {
+				errs = append(errs, errors.New("--enable-map-align with --tumor-fastq1 needs --RGID-tumor"))
+			}
+			if len(a.RGSMTumor) == 0 {
+				errs = append(errs, errors.New("--enable-map-align with --tumor-fastq1 needs --RGSM-tumor"))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalid, errors.Join(errs...))
+	}
+	return nil
+}
+
+// given returns the names of the name, value pairs with a value
+func given(pairs ...string) []string {
+	inputs := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if len(pairs[i+1]) > 0 {
+			inputs = append(inputs, pairs[i])
+		}
+	}
+	return inputs
+}
+
+// Input is an object or, for Prefix inputs such as the reference
+// directory, a prefix that DRAGEN reads
+type Input struct {
+	Option string
+	URI    string
+	Prefix bool
+}
+
+// inputOptions are the options naming DRAGEN inputs, in report order
+var inputOptions = []string{
+	"--ref-dir",
+	"--ht-reference",
+	"--fastq-file1",
+	"--fastq-file2",
+	"--fastq-list",
+	"--bam-input",
+	"--cram-input",
+	"--tumor-fastq1",
+	"--tumor-fastq2",
+	"--tumor-fastq-list",
+	"--tumor-bam-input",
+	"--tumor-cram-input",
+	"--variant",
+	"--variant-list",
+}
+
+// Inputs returns the inputs named in the arguments, every value of
+// repeated options included
+func (a *Args) Inputs() []Input {
+	inputs := []Input{}
+	for _, name := range inputOptions {
+		for _, option := range a.Options {
+			if option.Name == name && len(option.Value) > 0 {
+				inputs = append(inputs, Input{Option: name, URI: option.Value, Prefix: name == "--ref-dir"})
+			}
+		}
+	}
+	return inputs
+}
+
+// Mapping records a gs:// URI rewritten to the s3:// form used by the
+// DRAGEN S3 helper with the Cloud Storage interoperability endpoint
+type Mapping struct {
+	Option string
+	From   string
+	To     string
+}
+
+// TranslateGS returns a copy of args with every gs:// option value rewritten
+// to s3://, including values given as "--name=gs://...". URIs inside files
+// such as a FASTQ list are not rewritten.
+func TranslateGS(args []string) ([]string, []Mapping) {
+	translated := make([]string, len(args))
+	mappings := []Mapping{}
+	for i, token := range args {
+		translated[i] = token
+		name, value, hasValue := strings.Cut(token, "=")
+		prefix := name + "="
+		if !hasValue || !isOption(name) {
+			name, value, prefix = "", token, ""
+			if i > 0 && isOption(args[i-1]) {
+				name = args[i-1]
+			}
+		}
+		if long, ok := aliases[name]; ok {
+			name = long
+		}
+		if uri, ok := strings.CutPrefix(value, "gs://"); ok {
+			translated[i] = prefix + "s3://" + uri
+			mappings = append(mappings, Mapping{Option: name, From: value, To: "s3://" + uri})
+		}
+	}
+	return translated, mappings
+}
@@ -0,0 +1,274 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package dragenargs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// germline is the README example
+var germline = []string{
+	"-f",
+	"-r", "s3://bucket/4_2_reference",
+	"-1", "s3://bucket/HG002.novaseq.pcr-free.35x.R1.fastq.gz",
+	"-2", "s3://bucket/HG002.novaseq.pcr-free.35x.R2.fastq.gz",
+	"--RGID", "HG002",
+	"--RGSM", "HG002",
+	"--output-directory", "s3://bucket/output2",
+	"--output-file-prefix", "HG002_4_2",
+	"--enable-map-align", "true",
+	"--enable-map-align-output", "true",
+	"--output-format", "CRAM",
+	"--enable-duplicate-marking", "true",
+	"--enable-variant-caller", "true",
+	"--vc-enable-vcf-output", "true",
+	"--vc-emit-ref-confidence", "GVCF",
+	"--vc-frd-max-effective-depth", "40",
+	"--vc-enable-joint-detection", "true",
+	"--read-trimmers", "polyg",
+	"--soft-read-trimmers", "none",
+}
+
+func TestParse(t *testing.T) {
+	args, err := Parse(germline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.Reference != "s3://bucket/4_2_reference" || args.Fastq2 != "s3://bucket/HG002.novaseq.pcr-free.35x.R2.fastq.gz" ||
+		args.OutputDirectory != "s3://bucket/output2" || args.OutputFilePrefix != "HG002_4_2" ||
+		args.RGID != "HG002" || args.RGSM != "HG002" {
+		t.Errorf("Parse() returned %+v", args)
+	}
+	if !args.Enabled("map-align") || !args.Enabled("variant-caller") || args.Enabled("cnv") {
+		t.Errorf("Parse() enable switches %v", args.Enable)
+	}
+	if _, ok := args.Get("--force"); !ok {
+		t.Error("Parse() missed -f")
+	}
+	if value, _ := args.Get("--vc-frd-max-effective-depth"); value != "40" {
+		t.Errorf("Parse() returned --vc-frd-max-effective-depth %q", value)
+	}
+	if err := args.Validate(); err != nil {
+		t.Errorf("Validate() failed: %s", err.Error())
+	}
+	inputs := args.Inputs()
+	if len(inputs) != 3 || inputs[0] != (Input{Option: "--ref-dir", URI: "s3://bucket/4_2_reference", Prefix: true}) ||
+		inputs[2].Option != "--fastq-file2" || inputs[2].Prefix {
+		t.Errorf("Inputs() returned %+v", inputs)
+	}
+}
+
+func TestParseForms(t *testing.T) {
+	args, err := Parse([]string{"--output-directory=s3://bucket/out", "-n", "16", "--vc-min-qual", "-1.5", "--lic-server", "https://x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.OutputDirectory != "s3://bucket/out" {
+		t.Errorf("Parse() returned output directory %q", args.OutputDirectory)
+	}
+	if value, _ := args.Get("-n"); value != "16" {
+		t.Errorf("Parse() returned -n %q", value)
+	}
+	if value, _ := args.Get("--vc-min-qual"); value != "-1.5" {
+		t.Errorf("Parse() returned --vc-min-qual %q", value)
+	}
+}
+
+func TestParseSwitches(t *testing.T) {
+	args, err := Parse([]string{"--dry-run", "--ht-num-threads", "-1", "-r", "s3://bucket/ref",
+		"--vc-max-reads", "-2", "--output-directory", "s3://bucket/out", "--verbose",
+		"-1", "s3://bucket/R1.fastq.gz", "--lic-no-print"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"--dry-run":        "",
+		"--ht-num-threads": "-1",
+		"--vc-max-reads":   "-2",
+		"--verbose":        "",
+		"--lic-no-print":   "",
+	} {
+		if value, ok := args.Get(name); !ok || value != expected {
+			t.Errorf("Parse() returned %s %q", name, value)
+		}
+	}
+	if args.Fastq1 != "s3://bucket/R1.fastq.gz" || args.Reference != "s3://bucket/ref" {
+		t.Errorf("Parse() returned %+v", args)
+	}
+	args, err = Parse([]string{"--fastq-list", "-1", "--output-directory", "s3://bucket/out"})
+	if err != nil || args.FastqList != "-1" {
+		t.Errorf("Parse() returned --fastq-list %q: %v", args.FastqList, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := [][]string{
+		{"-output-directory", "s3://bucket/out"},
+		{"--output-directory"},
+		{"--RGID", "--RGSM", "x"},
+		{"--enable-map-align", "yes"},
+		{"s3://bucket/stray"},
+	}
+	for _, c := range cases {
+		if _, err := Parse(c); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Parse(%q) did not fail", c)
+		}
+	}
+}
+
+func TestParseRepeated(t *testing.T) {
+	args, err := Parse([]string{"-r", "ref", "--variant", "s3://bucket/a.g.vcf.gz", "--variant", "s3://bucket/b.g.vcf.gz",
+		"--RGID", "a", "--RGID", "b", "--output-directory", "out"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.RGID != "b" {
+		t.Errorf("Parse() kept RGID %q", args.RGID)
+	}
+	inputs := args.Inputs()
+	if len(inputs) != 3 || inputs[1].URI != "s3://bucket/a.g.vcf.gz" || inputs[2].URI != "s3://bucket/b.g.vcf.gz" {
+		t.Errorf("Inputs() returned %+v", inputs)
+	}
+}
+
+func without(args []string, names ...string) []string {
+	out := []string{}
+	for i := 0; i < len(args); i++ {
+		drop := false
+		for _, name := range names {
+			if args[i] == name {
+				drop = true
+			}
+		}
+		if drop {
+			i++
+			continue
+		}
+		out = append(out, args[i])
+	}
+	return out
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		args    []string
+		problem string
+	}{
+		{"no output directory", without(germline, "--output-directory"), "missing --output-directory"},
+		{"no reference", without(germline, "-r"), "needs reference"},
+		{"no RGID", without(germline, "--RGID"), "needs --RGID"},
+		{"no RGSM", without(germline, "--RGSM"), "needs --RGSM"},
+		{"no reads", without(germline, "-1", "-2"), "needs reads"},
+		{"-2 only", without(germline, "-1"), "-2 needs -1"},
+		{"two inputs", append(without(germline), "--bam-input", "s3://bucket/a.bam"), "conflicting inputs"},
+		{"two tumor inputs", append(without(germline), "--tumor-fastq-list", "list.csv", "--tumor-bam-input", "t.bam"), "conflicting inputs"},
+		{"no tumor RGSM", append(without(germline, "-1", "-2"), "--tumor-fastq1", "t1.fq", "--RGID-tumor", "T"), "needs --RGSM-tumor"},
+		{"hash table", []string{"--build-hash-table", "true", "--output-directory", "s3://bucket/ht"}, "needs --ht-reference"},
+	}
+	for _, c := range cases {
+		args, err := Parse(c.args)
+		if err != nil {
+			t.Errorf("%s: Parse() failed: %s", c.name, err.Error())
+			continue
+		}
+		if err := args.Validate(); !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), c.problem) {
+			t.Errorf("%s: Validate() returned %v", c.name, err)
+		}
+	}
+}
+
+func TestValidateWorkflows(t *testing.T) {
+	valid := [][]string{
+		// read groups come from the FASTQ list
+		{"-r", "ref", "--fastq-list", "s3://bucket/list.csv", "--fastq-list-sample-id", "S1",
+			"--enable-map-align", "true", "--output-directory", "out", "--output-file-prefix", "S1"},
+		// variant calling from an aligned BAM
+		{"-r", "ref", "-b", "s3://bucket/a.bam", "--enable-map-align", "false",
+			"--enable-variant-caller", "true", "--output-directory", "out", "--output-file-prefix", "a"},
+		{"--build-hash-table", "true", "--ht-reference", "hg38.fa", "--output-directory", "s3://bucket/ht"},
+		// tumor-only somatic run without an output prefix
+		{"-r", "ref", "--tumor-fastq1", "t1.fq", "--tumor-fastq2", "t2.fq", "--RGID-tumor", "T", "--RGSM-tumor", "T",
+			"--enable-map-align", "true", "--enable-variant-caller", "true", "--output-directory", "out"},
+		{"-r", "ref", "--tumor-bam-input", "t.bam", "--enable-map-align", "true", "--output-directory", "out"},
+		// joint genotyping and gVCF merge read variants only
+		{"-r", "ref", "--enable-joint-genotyping", "true", "--variant-list", "s3://bucket/gvcfs.txt",
+			"--output-directory", "out", "--output-file-prefix", "cohort"},
+		{"-r", "ref", "--enable-combinegvcfs", "true", "--variant", "a.g.vcf.gz", "--variant", "b.g.vcf.gz",
+			"--output-directory", "out"},
+		{"--version"},
+	}
+	for _, c := range valid {
+		args, err := Parse(c)
+		if err == nil {
+			err = args.Validate()
+		}
+		if err != nil {
+			t.Errorf("%q: %s", c, err.Error())
+		}
+	}
+}
+
+func TestTranslateGS(t *testing.T) {
+	args := []string{
+		"-r", "gs://bucket/ref",
+		"-1", "gs://bucket/R1.fastq.gz",
+		"-2", "s3://bucket/R2.fastq.gz",
+		"--output-directory=gs://bucket/out",
+		"--output-file-prefix", "gs-sample",
+	}
+	translated, mappings := TranslateGS(args)
+	expected := []string{
+		"-r", "s3://bucket/ref",
+		"-1", "s3://bucket/R1.fastq.gz",
+		"-2", "s3://bucket/R2.fastq.gz",
+		"--output-directory=s3://bucket/out",
+		"--output-file-prefix", "gs-sample",
+	}
+	if strings.Join(translated, " ") != strings.Join(expected, " ") {
+		t.Errorf("TranslateGS() returned %q", translated)
+	}
+	if args[1] != "gs://bucket/ref" {
+		t.Error("TranslateGS() modified its input")
+	}
+	if len(mappings) != 3 {
+		t.Fatalf("TranslateGS() returned %d mappings", len(mappings))
+	}
+	if mappings[0] != (Mapping{Option: "--ref-dir", From: "gs://bucket/ref", To: "s3://bucket/ref"}) ||
+		mappings[2].Option != "--output-directory" {
+		t.Errorf("TranslateGS() returned %+v", mappings)
+	}
+	if _, mappings := TranslateGS(germline); len(mappings) != 0 {
+		t.Errorf("TranslateGS() rewrote s3:// arguments: %+v", mappings)
+	}
+}
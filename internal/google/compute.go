@@ -31,25 +31,31 @@ policies, either expressed or implied, of Nimbix, Inc.
 package google
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"io"
-	"os"
-	"os/exec"
+	"maps"
+	"net/http"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	compute "cloud.google.com/go/compute/apiv1"
 	"cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 	"jarvice.io/dragen/config"
 	"jarvice.io/dragen/internal/logger"
 )
 
+// computeOptions are passed to every Compute Engine client, set in tests
+var computeOptions []option.ClientOption
+
 func createInstanceClient() (context.Context, *compute.InstancesClient, error) {
 	ctx := context.Background()
-	instancesClient, err := compute.NewInstancesRESTClient(ctx)
+	instancesClient, err := compute.NewInstancesRESTClient(ctx, computeOptions...)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -59,7 +65,7 @@ func createInstanceClient() (context.Context, *compute.InstancesClient, error) {
 
 func createSubnetClient() (context.Context, *compute.SubnetworksClient, error) {
 	ctx := context.Background()
-	subnetClient, err := compute.NewSubnetworksRESTClient(ctx)
+	subnetClient, err := compute.NewSubnetworksRESTClient(ctx, computeOptions...)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -69,7 +75,7 @@ func createSubnetClient() (context.Context, *compute.SubnetworksClient, error) {
 
 func createReservationsClient() (context.Context, *compute.ReservationsClient, error) {
 	ctx := context.Background()
-	reservationClient, err := compute.NewReservationsRESTClient(ctx)
+	reservationClient, err := compute.NewReservationsRESTClient(ctx, computeOptions...)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -80,7 +86,7 @@ func createReservationsClient() (context.Context, *compute.ReservationsClient, e
 func createTemplatesClient() (context.Context, *compute.InstanceTemplatesClient, error) {
 
 	ctx := context.Background()
-	templateClient, err := compute.NewInstanceTemplatesRESTClient(ctx)
+	templateClient, err := compute.NewInstanceTemplatesRESTClient(ctx, computeOptions...)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -88,8 +94,18 @@ func createTemplatesClient() (context.Context, *compute.InstanceTemplatesClient,
 	return ctx, templateClient, nil
 }
 
+// IsNotFound reports whether err is a Compute Engine 404, e.g. when deleting
+// an object the meter VM already removed
+func IsNotFound(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusNotFound
+}
+
+// GoogleCompute manages Compute Engine objects in the project and zone of
+// the calling VM, or of an explicit configuration outside Google Cloud where
+// name and id are empty
 type GoogleCompute struct {
-	project, zone, network, name, id string
+	project, zone, network, subnet, name, id string
 }
 
 func NewGoogleCompute() (*GoogleCompute, error) {
@@ -123,6 +139,25 @@ func NewGoogleCompute() (*GoogleCompute, error) {
 	}, nil
 }
 
+// NewGoogleComputeWithConfig skips the metadata server and uses Application
+// Default Credentials, e.g. on a workstation or CI runner. Without a subnet
+// the first subnet of network in the zone region is used, a subnet may also
+// be given as a resource path.
+func NewGoogleComputeWithConfig(project, zone, network, subnet string) (*GoogleCompute, error) {
+	if len(project) == 0 || len(zone) == 0 {
+		return nil, errors.New("project and zone are required outside Google Compute Engine")
+	}
+	if len(network) == 0 {
+		network = "default"
+	}
+	return &GoogleCompute{
+		project: project,
+		zone:    zone,
+		network: filepath.Base(network),
+		subnet:  subnet,
+	}, nil
+}
+
 func (vm GoogleCompute) GetName() string {
 	return vm.name
 }
@@ -141,38 +176,40 @@ func (vm GoogleCompute) GetId() string {
 
 func (vm GoogleCompute) CreateInstanceContainer(name, template, container, shutdownScript, containerCmd string, containerArgs ...string) error {
 
-	args := []string{
-		"compute", "instances", "create-with-container", name,
-		"--project", vm.project,
-		"--zone", vm.zone,
-		"--source-instance-template", template,
-		"--metadata shutdown-script=\"" + shutdownScript + "\"",
-		"--container-image", container,
-		"--container-restart-policy", "always",
-		"--container-command", containerCmd,
-		"--no-shielded-secure-boot",
-		"--shielded-vtpm",
-		"--shielded-integrity-monitoring",
-		"--labels",
-		fmt.Sprintf("container-vm=%s", config.DragenImage),
-	}
-	for _, arg := range containerArgs {
-		args = append(args, fmt.Sprintf("--container-arg=%s", arg))
+	ctx, instanceClient, err := createInstanceClient()
+	if err != nil {
+		return err
 	}
-	cmd := exec.Command("/usr/bin/gcloud", args...)
+	defer instanceClient.Close()
 
-	var stdBuffer bytes.Buffer
-	mw := io.MultiWriter(os.Stdout, &stdBuffer)
+	myTemplate := "/projects/" + vm.project + "/global/instanceTemplates/" + template
+	shutdown := "shutdown-script"
+	items := append(containerMetadata(name, container, containerCmd, containerArgs...),
+		&computepb.Items{
+			Key:   &shutdown,
+			Value: &shutdownScript,
+		})
 
-	cmd.Stdout = mw
-	cmd.Stderr = mw
+	req := &computepb.InsertInstanceRequest{
+		InstanceResource: &computepb.Instance{
+			Name:                   &name,
+			Labels:                 containerLabels(),
+			Metadata:               &computepb.Metadata{Items: items},
+			ShieldedInstanceConfig: containerShieldedConfig(),
+		},
+		Project:                vm.project,
+		SourceInstanceTemplate: &myTemplate,
+		Zone:                   vm.zone,
+	}
 
-	err := cmd.Run()
+	op, err := instanceClient.Insert(ctx, req)
 	if err != nil {
-		return err
+		return errors.New("unable to create container VM " + name + ": " + err.Error())
 	}
 
-	logger.Ologger.Debug(stdBuffer.String())
+	if err = op.Wait(ctx); err != nil {
+		return errors.New("unable to create container VM " + name + ": " + err.Error())
+	}
 
 	logger.Ologger.Info(name + " container VM created")
 
@@ -211,7 +248,7 @@ func (vm GoogleCompute) CreateInstanceWithJobId(name, template, reservation, job
 	}
 	for _, item := range metadata {
 		if len(jobid) > 0 {
-			if *item.Key == "gce-container-declaration" {
+			if *item.Key == containerDeclarationKey {
 				re := regexp.MustCompile(`(TEMP_JOB_ID)`)
 				s := re.ReplaceAllString(*item.Value, jobid)
 				item.Value = &s
@@ -253,6 +290,130 @@ func (vm GoogleCompute) CreateInstanceWithJobId(name, template, reservation, job
 	return nil
 }
 
+// GetInstanceId returns the numeric ID of instance name
+func (vm GoogleCompute) GetInstanceId(name string) (string, error) {
+
+	ctx, instanceClient, err := createInstanceClient()
+	if err != nil {
+		return "", err
+	}
+	defer instanceClient.Close()
+
+	instance, err := instanceClient.Get(ctx, &computepb.GetInstanceRequest{
+		Instance: name,
+		Project:  vm.project,
+		Zone:     vm.zone,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatUint(instance.GetId(), 10), nil
+}
+
+// SetInstanceMetadata adds or replaces metadata items of instance name
+func (vm GoogleCompute) SetInstanceMetadata(name string, items map[string]string) error {
+
+	ctx, instanceClient, err := createInstanceClient()
+	if err != nil {
+		return err
+	}
+	defer instanceClient.Close()
+
+	instance, err := instanceClient.Get(ctx, &computepb.GetInstanceRequest{
+		Instance: name,
+		Project:  vm.project,
+		Zone:     vm.zone,
+	})
+	if err != nil {
+		return err
+	}
+
+	metadata := instance.GetMetadata()
+	if metadata == nil {
+		metadata = &computepb.Metadata{}
+	}
+	pending := maps.Clone(items)
+	for _, item := range metadata.Items {
+		if value, ok := pending[item.GetKey()]; ok {
+			item.Value = &value
+			delete(pending, item.GetKey())
+		}
+	}
+	for key, value := range pending {
+		key, value := key, value
+		metadata.Items = append(metadata.Items, &computepb.Items{
+			Key:   &key,
+			Value: &value,
+		})
+	}
+
+	// the fingerprint from Get rejects concurrent metadata updates
+	op, err := instanceClient.SetMetadata(ctx, &computepb.SetMetadataInstanceRequest{
+		Instance:         name,
+		MetadataResource: metadata,
+		Project:          vm.project,
+		Zone:             vm.zone,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = op.Wait(ctx); err != nil {
+		return err
+	}
+
+	logger.Ologger.Info(name + " instance metadata updated")
+
+	return nil
+}
+
+// SetInstanceLabels adds or replaces labels of instance name
+func (vm GoogleCompute) SetInstanceLabels(name string, labels map[string]string) error {
+
+	ctx, instanceClient, err := createInstanceClient()
+	if err != nil {
+		return err
+	}
+	defer instanceClient.Close()
+
+	instance, err := instanceClient.Get(ctx, &computepb.GetInstanceRequest{
+		Instance: name,
+		Project:  vm.project,
+		Zone:     vm.zone,
+	})
+	if err != nil {
+		return err
+	}
+
+	merged := maps.Clone(instance.GetLabels())
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	maps.Copy(merged, labels)
+
+	op, err := instanceClient.SetLabels(ctx, &computepb.SetLabelsInstanceRequest{
+		Instance: name,
+		InstancesSetLabelsRequestResource: &computepb.InstancesSetLabelsRequest{
+			LabelFingerprint: instance.LabelFingerprint,
+			Labels:           merged,
+		},
+		Project: vm.project,
+		Zone:    vm.zone,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = op.Wait(ctx); err != nil {
+		return err
+	}
+
+	logger.Ologger.Info(name + " instance labels updated")
+
+	return nil
+}
+
 func (vm GoogleCompute) DeleteInstance(name string) error {
 	return vm.DeleteInstanceWait(name, true)
 }
@@ -368,9 +529,11 @@ func (vm GoogleCompute) InstanceExistWithError(filter string) (bool, error) {
 
 	if _, err := instances.Next(); err == nil {
 		return true, nil
+	} else if err != iterator.Done {
+		return false, err
 	}
 
-	return false, err
+	return false, nil
 }
 
 func (vm GoogleCompute) CreateReservation(name, template string) error {
@@ -458,6 +621,15 @@ func (vm GoogleCompute) DeleteReservationWait(name string, wait bool) error {
 	return nil
 }
 
+// subnetwork returns the configured subnet or the first one of the network
+// in region
+func (vm GoogleCompute) subnetwork(region string) (string, error) {
+	if len(vm.subnet) > 0 {
+		return vm.subnet, nil
+	}
+	return vm.getSubnet(vm.network, region)
+}
+
 func (vm GoogleCompute) getSubnet(network, region string) (string, error) {
 
 	ctx, subnetClient, err := createSubnetClient()
@@ -493,7 +665,7 @@ func (vm GoogleCompute) CreateTemplate(name, serviceAccount string) error {
 	defer templateClient.Close()
 
 	region := strings.Join(strings.Split(vm.zone, "-")[:2], "-")
-	subnet, err := vm.getSubnet(vm.network, region)
+	subnet, err := vm.subnetwork(region)
 	if err != nil {
 		return err
 	}
@@ -587,61 +759,196 @@ func (vm GoogleCompute) CreateTemplate(name, serviceAccount string) error {
 	return nil
 }
 
-func (vm GoogleCompute) CreateInstanceTemplates(name, serviceAccount, container, containerCmd string, containerArgs ...string) error {
+func (vm GoogleCompute) CreateInstanceTemplates(name, serviceAccount string, spec TemplateSpec, container, containerCmd string, containerArgs ...string) error {
 
-	region := strings.Join(strings.Split(vm.zone, "-")[:2], "-")
-	subnet, err := vm.getSubnet(vm.network, region)
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	ctx, templateClient, err := createTemplatesClient()
 	if err != nil {
 		return err
 	}
-	templateArgs := [...]string{
-		"compute", "instance-templates", "create-with-container", name,
-		"--project", vm.project,
-		"--machine-type", config.GoogleMachine,
-		"--network", vm.network,
-		"--subnet", subnet,
-		"--region", region,
-		"--maintenance-policy", "MIGRATE",
-		"--service-account", serviceAccount,
-		"--scopes", "https://www.googleapis.com/auth/cloud-platform",
-		"--image-project", config.DragenProject,
-		"--image", config.DragenImage,
-		"--create-disk", "auto-delete=yes,device-name=dargen-1,mode=rw,size=10,type=pd-balanced",
-		"--no-shielded-secure-boot",
-		"--shielded-vtpm",
-		"--shielded-integrity-monitoring",
-		"--labels", fmt.Sprint("container-vm=", config.DragenImage),
-		"--container-image", container,
-		"--container-restart-policy", "always",
-		"--container-command", containerCmd,
+	defer templateClient.Close()
+
+	region := strings.Join(strings.Split(vm.zone, "-")[:2], "-")
+	subnet := spec.Subnet
+	if len(subnet) == 0 {
+		if subnet, err = vm.subnetwork(region); err != nil {
+			return err
+		}
 	}
 
-	var args []string
-	for _, arg := range templateArgs {
-		args = append(args, arg)
+	req := &computepb.InsertInstanceTemplateRequest{
+		Project: vm.project,
+		InstanceTemplateResource: vm.containerTemplate(name, serviceAccount, region, subnet, spec,
+			container, containerCmd, containerArgs...),
 	}
-	for _, arg := range containerArgs {
-		args = append(args, fmt.Sprintf("--container-arg=%s", arg))
+
+	op, err := templateClient.Insert(ctx, req)
+	if err != nil {
+		return errors.New("unable to create template " + name + ": " + err.Error())
+	}
+
+	if err = op.Wait(ctx); err != nil {
+		return errors.New("unable to create template " + name + ": " + err.Error())
 	}
 
-	cmd := exec.Command("/usr/bin/gcloud", args...)
+	logger.Ologger.Info(name + " template created")
+
+	return nil
+}
 
-	var stdBuffer bytes.Buffer
-	mw := io.Writer(&stdBuffer)
+// containerTemplate describes a Container-Optimized OS DRAGEN image VM that
+// runs container, matching gcloud instance-templates create-with-container.
+// A subnet given as a resource path, e.g. in a Shared VPC host project, also
+// determines the network.
+func (vm GoogleCompute) containerTemplate(name, serviceAccount, region, subnet string, spec TemplateSpec, container, containerCmd string, containerArgs ...string) *computepb.InstanceTemplate {
 
-	cmd.Stdout = mw
-	cmd.Stderr = mw
+	description := "golang container template for dragen"
+	vmTrue := true
+	vmFalse := false
+	machine := spec.MachineType
+	bootDeviceName := "persistent-disk-0"
+	dataDeviceName := "dargen-1"
+	mode := "READ_WRITE"
+	diskType := "PERSISTENT"
+	dataDiskType := spec.DiskType
+	dataDiskSize := spec.DiskSizeGb
+	source := "projects/" + config.DragenProject + "/global/images/" + config.DragenImage
+	networkName := "nic0"
+	accessName := "external-nat"
+	networkTier := "PREMIUM"
+	networkType := "ONE_TO_ONE_NAT"
+	schedulingMaint := spec.MaintenancePolicy
+	schedulingProv := "STANDARD"
+	saEmail := serviceAccount
 
-	err = cmd.Run()
-	if err != nil {
-		return err
+	networkInterface := &computepb.NetworkInterface{
+		Name: &networkName,
+	}
+	if strings.Contains(subnet, "/") {
+		networkInterface.Subnetwork = &subnet
+	} else {
+		network := "/projects/" + vm.project + "/global/networks/" + vm.network
+		subnetwork := "/projects/" + vm.project + "/regions/" + region + "/subnetworks/" + subnet
+		networkInterface.Network = &network
+		networkInterface.Subnetwork = &subnetwork
+	}
+	if !spec.NoExternalIP {
+		networkInterface.AccessConfigs = []*computepb.AccessConfig{
+			&computepb.AccessConfig{
+				Name:        &accessName,
+				NetworkTier: &networkTier,
+				Type:        &networkType,
+			},
+		}
 	}
 
-	logger.Ologger.Debug(stdBuffer.String())
+	var encryptionKey *computepb.CustomerEncryptionKey
+	if len(spec.KMSKey) > 0 {
+		kmsKey := spec.KMSKey
+		encryptionKey = &computepb.CustomerEncryptionKey{KmsKeyName: &kmsKey}
+	}
 
-	logger.Ologger.Info(name + " template created")
+	labels := containerLabels()
+	for key, value := range spec.Labels {
+		labels[key] = value
+	}
 
-	return nil
+	properties := &computepb.InstanceProperties{
+		CanIpForward: &vmFalse,
+		Disks: []*computepb.AttachedDisk{
+			&computepb.AttachedDisk{
+				AutoDelete:        &vmTrue,
+				Boot:              &vmTrue,
+				DeviceName:        &bootDeviceName,
+				DiskEncryptionKey: encryptionKey,
+				InitializeParams: &computepb.AttachedDiskInitializeParams{
+					SourceImage: &source,
+				},
+				Mode: &mode,
+				Type: &diskType,
+			},
+			&computepb.AttachedDisk{
+				AutoDelete:        &vmTrue,
+				Boot:              &vmFalse,
+				DeviceName:        &dataDeviceName,
+				DiskEncryptionKey: encryptionKey,
+				InitializeParams: &computepb.AttachedDiskInitializeParams{
+					DiskSizeGb: &dataDiskSize,
+					DiskType:   &dataDiskType,
+				},
+				Mode: &mode,
+				Type: &diskType,
+			},
+		},
+		Labels:      labels,
+		MachineType: &machine,
+		Metadata: &computepb.Metadata{
+			Items: containerMetadata(name, container, containerCmd, containerArgs...),
+		},
+		NetworkInterfaces: []*computepb.NetworkInterface{
+			networkInterface,
+		},
+		Scheduling: &computepb.Scheduling{
+			AutomaticRestart:  &vmTrue,
+			OnHostMaintenance: &schedulingMaint,
+			Preemptible:       &vmFalse,
+			ProvisioningModel: &schedulingProv,
+		},
+		ServiceAccounts: []*computepb.ServiceAccount{
+			&computepb.ServiceAccount{
+				Email: &saEmail,
+				Scopes: []string{
+					"https://www.googleapis.com/auth/cloud-platform",
+				},
+			},
+		},
+		ShieldedInstanceConfig: containerShieldedConfig(),
+	}
+	if len(spec.Tags) > 0 {
+		properties.Tags = &computepb.Tags{Items: spec.Tags}
+	}
+
+	return &computepb.InstanceTemplate{
+		Description: &description,
+		Name:        &name,
+		Properties:  properties,
+	}
+}
+
+func containerMetadata(name, container, containerCmd string, containerArgs ...string) []*computepb.Items {
+	declarationKey := containerDeclarationKey
+	declaration := ContainerDeclaration(name, container, containerCmd, containerArgs...)
+	loggingKey := loggingEnabledKey
+	logging := "true"
+	return []*computepb.Items{
+		&computepb.Items{
+			Key:   &declarationKey,
+			Value: &declaration,
+		},
+		&computepb.Items{
+			Key:   &loggingKey,
+			Value: &logging,
+		},
+	}
+}
+
+func containerLabels() map[string]string {
+	return map[string]string{
+		"container-vm": config.DragenImage,
+	}
+}
+
+func containerShieldedConfig() *computepb.ShieldedInstanceConfig {
+	vmTrue := true
+	vmFalse := false
+	return &computepb.ShieldedInstanceConfig{
+		EnableIntegrityMonitoring: &vmTrue,
+		EnableSecureBoot:          &vmFalse,
+		EnableVtpm:                &vmTrue,
+	}
 }
 
 func (vm GoogleCompute) DeleteTemplate(name string) error {
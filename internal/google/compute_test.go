@@ -31,11 +31,16 @@ policies, either expressed or implied, of Nimbix, Inc.
 package google
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"google.golang.org/api/option"
 )
 
 func googleMetadataServer() {
@@ -82,3 +87,48 @@ func TestGoogleCompute(t *testing.T) {
 		}
 	}
 }
+
+// computeTransport answers Compute Engine calls with status and body
+// without a server
+type computeTransport struct {
+	status int
+	body   string
+	paths  []string
+}
+
+func (c *computeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.paths = append(c.paths, r.URL.Path+"?"+r.URL.Query().Get("filter"))
+	return &http.Response{
+		StatusCode: c.status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader([]byte(c.body))),
+		Request:    r,
+	}, nil
+}
+
+func TestInstanceExistWithError(t *testing.T) {
+	defer func() { computeOptions = nil }()
+	vm := GoogleCompute{project: "google-project", zone: "us-central1-a"}
+	cases := []struct {
+		status int
+		body   string
+		exists bool
+		err    bool
+	}{
+		{http.StatusOK, `{"items": [{"name": "batch-vm"}]}`, true, false},
+		// an empty list is no error
+		{http.StatusOK, `{}`, false, false},
+		{http.StatusForbidden, `{"error": {"code": 403, "message": "permission denied"}}`, false, true},
+	}
+	for _, c := range cases {
+		transport := &computeTransport{status: c.status, body: c.body}
+		computeOptions = []option.ClientOption{option.WithHTTPClient(&http.Client{Transport: transport})}
+		exists, err := vm.InstanceExistWithError("batch-vm")
+		if exists != c.exists || (err != nil) != c.err {
+			t.Errorf("InstanceExistWithError() with HTTP %d returned %t, %v", c.status, exists, err)
+		}
+		if len(transport.paths) != 1 || !strings.HasPrefix(transport.paths[0], "/compute/v1/projects/google-project/zones/us-central1-a/instances?name =batch-vm") {
+			t.Errorf("InstanceExistWithError() requested %v", transport.paths)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package google
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+const (
+	containerDeclarationKey = "gce-container-declaration"
+	loggingEnabledKey       = "google-logging-enabled"
+)
+
+// ContainerDeclaration returns the gce-container-declaration metadata read by
+// the Container-Optimized OS konlet agent, in the layout written by gcloud
+// create-with-container. Every string is emitted as a double-quoted scalar
+// so arguments never need YAML escaping rules of their own.
+func ContainerDeclaration(name, image, command string, args ...string) string {
+	var b strings.Builder
+	b.WriteString("# DISCLAIMER:\n")
+	b.WriteString("# This container declaration format is not a public API and may change without\n")
+	b.WriteString("# notice. Please use gcloud command-line tool or Google Cloud Console to run\n")
+	b.WriteString("# Containers on Google Compute Engine.\n\n")
+	b.WriteString("spec:\n")
+	b.WriteString("  containers:\n")
+	if len(args) > 0 {
+		b.WriteString("  - args:\n")
+		for _, arg := range args {
+			b.WriteString("    - " + yamlString(arg) + "\n")
+		}
+		b.WriteString("    command:\n")
+	} else {
+		b.WriteString("  - command:\n")
+	}
+	b.WriteString("    - " + yamlString(command) + "\n")
+	b.WriteString("    image: " + yamlString(image) + "\n")
+	b.WriteString("    name: " + yamlString(name) + "\n")
+	b.WriteString("    securityContext:\n")
+	b.WriteString("      privileged: false\n")
+	b.WriteString("    stdin: false\n")
+	b.WriteString("    tty: false\n")
+	b.WriteString("    volumeMounts: []\n")
+	b.WriteString("  restartPolicy: Always\n")
+	b.WriteString("  volumes: []\n")
+	return b.String()
+}
+
+// yamlString quotes s as a JSON string, which is also a valid YAML
+// double-quoted scalar
+func yamlString(s string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
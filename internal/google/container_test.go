@@ -0,0 +1,104 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package google
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"jarvice.io/dragen/config"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func golden(t *testing.T, name, got string) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(expected) {
+		t.Errorf("%s mismatch, got:\n%s", path, got)
+	}
+}
+
+func TestContainerDeclaration(t *testing.T) {
+	golden(t, "container-declaration.yaml", ContainerDeclaration("dragen-0123456789ab",
+		"us-docker.pkg.dev/jarvice/images/jarvice-dragen-meter:1.0",
+		"/usr/local/bin/entrypoint",
+		"--api-host", "https://illumina.nimbix.net/api",
+		"--username-secret", "projects/p/secrets/user/versions/latest",
+		"--job-id", "TEMP_JOB_ID",
+		"--service-name", "batch-vm",
+		// values YAML would otherwise read as other types or syntax
+		"true", "0755", "- item", "key: value", "# comment", `quote " and \ backslash`, "", "tab\tnewline\n", "<&>"))
+}
+
+func TestContainerDeclarationNoArgs(t *testing.T) {
+	golden(t, "container-declaration-no-args.yaml", ContainerDeclaration("meter", "meter:latest", "/entrypoint"))
+}
+
+func TestContainerTemplate(t *testing.T) {
+	vm := GoogleCompute{project: "google-project", zone: "us-central1-a", network: "default"}
+	template := vm.containerTemplate("dragen-abc", "sa@google-project.iam.gserviceaccount.com",
+		"us-central1", "default", DefaultTemplateSpec(), "meter:1.0", "/usr/local/bin/entrypoint", "--job-id", "TEMP_JOB_ID")
+	properties := template.Properties
+	if template.GetName() != "dragen-abc" || properties.GetMachineType() != config.GoogleMachine {
+		t.Errorf("containerTemplate() returned %v", template)
+	}
+	if source := properties.Disks[0].InitializeParams.GetSourceImage(); source != "projects/atos-illumina-public/global/images/dragen-cos" {
+		t.Errorf("containerTemplate() boot image %q", source)
+	}
+	if subnet := properties.NetworkInterfaces[0].GetSubnetwork(); subnet != "/projects/google-project/regions/us-central1/subnetworks/default" {
+		t.Errorf("containerTemplate() subnetwork %q", subnet)
+	}
+	if properties.Labels["container-vm"] != config.DragenImage {
+		t.Errorf("containerTemplate() labels %v", properties.Labels)
+	}
+	metadata := map[string]string{}
+	for _, item := range properties.Metadata.Items {
+		metadata[item.GetKey()] = item.GetValue()
+	}
+	if metadata[containerDeclarationKey] != ContainerDeclaration("dragen-abc", "meter:1.0", "/usr/local/bin/entrypoint", "--job-id", "TEMP_JOB_ID") ||
+		metadata[loggingEnabledKey] != "true" {
+		t.Errorf("containerTemplate() metadata %v", metadata)
+	}
+	if shielded := properties.ShieldedInstanceConfig; shielded.GetEnableSecureBoot() || !shielded.GetEnableVtpm() {
+		t.Errorf("containerTemplate() shielded config %v", shielded)
+	}
+}
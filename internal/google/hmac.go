@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package google
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+	"jarvice.io/dragen/internal/logger"
+)
+
+// HMACKey is a Cloud Storage HMAC key usable with the S3 compatible API
+type HMACKey struct {
+	AccessID string
+	Secret   string
+}
+
+// HMACKeyManager creates and removes Cloud Storage HMAC keys for a service
+// account. DeleteHMACKey deactivates the key before deleting it.
+type HMACKeyManager interface {
+	CreateHMACKey(ctx context.Context, serviceAccount string) (HMACKey, error)
+	DeleteHMACKey(ctx context.Context, accessID string) error
+}
+
+// StorageHMAC manages HMAC keys of a project through the Cloud Storage JSON API
+type StorageHMAC struct {
+	project string
+	service *storage.Service
+}
+
+func NewStorageHMAC(ctx context.Context, project string, opts ...option.ClientOption) (*StorageHMAC, error) {
+	service, err := storage.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &StorageHMAC{project: project, service: service}, nil
+}
+
+func (h *StorageHMAC) CreateHMACKey(ctx context.Context, serviceAccount string) (HMACKey, error) {
+	key, err := h.service.Projects.HmacKeys.Create(h.project, serviceAccount).Context(ctx).Do()
+	if err != nil {
+		return HMACKey{}, err
+	}
+	if key.Metadata == nil {
+		return HMACKey{}, errors.New("HMAC key for " + serviceAccount + " created without metadata")
+	}
+	logger.Ologger.Info("HMAC key " + key.Metadata.AccessId + " created for " + serviceAccount)
+	return HMACKey{AccessID: key.Metadata.AccessId, Secret: key.Secret}, nil
+}
+
+func (h *StorageHMAC) DeleteHMACKey(ctx context.Context, accessID string) error {
+	if _, err := h.service.Projects.HmacKeys.Update(h.project, accessID,
+		&storage.HmacKeyMetadata{State: "INACTIVE"}).Context(ctx).Do(); err != nil {
+		return err
+	}
+	if err := h.service.Projects.HmacKeys.Delete(h.project, accessID).Context(ctx).Do(); err != nil {
+		return err
+	}
+	logger.Ologger.Info("HMAC key " + accessID + " deleted")
+	return nil
+}
+
+// FakeHMAC keeps HMAC keys in memory, standing in for Cloud Storage in tests
+type FakeHMAC struct {
+	mu      sync.Mutex
+	next    int
+	Keys    map[string]string
+	Deleted []string
+	Err     error
+}
+
+func (f *FakeHMAC) CreateHMACKey(ctx context.Context, serviceAccount string) (HMACKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return HMACKey{}, f.Err
+	}
+	if f.Keys == nil {
+		f.Keys = map[string]string{}
+	}
+	f.next += 1
+	key := HMACKey{
+		AccessID: "GOOG1FAKE" + strconv.Itoa(f.next),
+		Secret:   "secret-" + serviceAccount,
+	}
+	f.Keys[key.AccessID] = serviceAccount
+	return key, nil
+}
+
+func (f *FakeHMAC) DeleteHMACKey(ctx context.Context, accessID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.Keys[accessID]; !ok {
+		return errors.New("HMAC key " + accessID + " not found")
+	}
+	delete(f.Keys, accessID)
+	f.Deleted = append(f.Deleted, accessID)
+	return nil
+}
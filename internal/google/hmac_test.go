@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package google
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+// storageTransport answers Cloud Storage HMAC key calls without a server,
+// the package tests already hold the -httptest.serve address
+type storageTransport struct {
+	requests []string
+}
+
+func (s *storageTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	body := []byte{}
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+	}
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path+" "+strings.TrimSpace(string(body)))
+	var resp any
+	switch r.Method {
+	case http.MethodPost:
+		resp = storage.HmacKey{
+			Metadata: &storage.HmacKeyMetadata{AccessId: "GOOG1EXAMPLE", State: "ACTIVE"},
+			Secret:   "secret",
+		}
+	case http.MethodPut:
+		resp = storage.HmacKeyMetadata{AccessId: "GOOG1EXAMPLE", State: "INACTIVE"}
+	}
+	blob, _ := json.Marshal(resp)
+	if resp == nil {
+		blob = nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(blob)),
+		Request:    r,
+	}, nil
+}
+
+func TestStorageHMAC(t *testing.T) {
+	transport := &storageTransport{}
+	ctx := context.Background()
+	hmac, err := NewStorageHMAC(ctx, "google-project",
+		option.WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := hmac.CreateHMACKey(ctx, "dragen@google-project.iam.gserviceaccount.com")
+	if err != nil || key.AccessID != "GOOG1EXAMPLE" || key.Secret != "secret" {
+		t.Fatalf("CreateHMACKey() returned %v %v", key, err)
+	}
+	if err := hmac.DeleteHMACKey(ctx, key.AccessID); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"POST /storage/v1/projects/google-project/hmacKeys ",
+		`PUT /storage/v1/projects/google-project/hmacKeys/GOOG1EXAMPLE {"state":"INACTIVE"}`,
+		"DELETE /storage/v1/projects/google-project/hmacKeys/GOOG1EXAMPLE ",
+	}
+	if strings.Join(transport.requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected requests:\n%s", strings.Join(transport.requests, "\n"))
+	}
+}
+
+func TestFakeHMAC(t *testing.T) {
+	fake := &FakeHMAC{}
+	ctx := context.Background()
+	key, err := fake.CreateHMACKey(ctx, "dragen")
+	if err != nil || fake.Keys[key.AccessID] != "dragen" {
+		t.Fatalf("CreateHMACKey() failed: %v", err)
+	}
+	if err := fake.DeleteHMACKey(ctx, key.AccessID); err != nil || len(fake.Keys) != 0 {
+		t.Errorf("DeleteHMACKey() failed: %v", err)
+	}
+	if err := fake.DeleteHMACKey(ctx, key.AccessID); err == nil {
+		t.Error("DeleteHMACKey() of a deleted key did not fail")
+	}
+}
@@ -31,9 +31,12 @@ policies, either expressed or implied, of Nimbix, Inc.
 package google
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"jarvice.io/dragen/config"
 	"jarvice.io/dragen/internal/logger"
@@ -62,6 +65,53 @@ func googleMetadata(path string) (string, error) {
 	return ret, nil
 }
 
+// instanceAttribute returns the custom instance metadata attribute name and
+// whether it is set
+func instanceAttribute(ctx context.Context, name string) (string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://metadata.google.internal/computeMetadata/v1/instance/attributes/"+name, nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return "", false, errors.New("metadata attribute " + name + ": " + resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", false, err
+	}
+	value := strings.TrimSpace(string(body))
+	return value, len(value) > 0, nil
+}
+
+// WaitForAttribute polls the custom instance metadata attribute name every
+// interval until it is set or ctx is done
+func WaitForAttribute(ctx context.Context, name string, interval time.Duration) (string, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		value, ok, err := instanceAttribute(ctx, name)
+		if ok {
+			return value, nil
+		} else if err != nil {
+			logger.Ologger.Warn(err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
 func CheckDragenLicense() bool {
 	dragen := config.DragenLic
 	query, err := googleMetadata("/instance/licenses/")
@@ -0,0 +1,328 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package google
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"google.golang.org/api/googleapi"
+)
+
+// ComputeProvider is the Compute Engine surface used by the batch service and
+// the meter VM: identity of the calling VM plus the templates, reservations
+// and instances created for a job. GetName and GetId are empty outside
+// Google Compute Engine.
+type ComputeProvider interface {
+	GetName() string
+	GetProject() string
+	GetZone() string
+	GetId() string
+
+	CreateInstanceTemplates(name, serviceAccount string, spec TemplateSpec, container, containerCmd string, containerArgs ...string) error
+	DeleteTemplate(name string) error
+	DeleteTemplateWait(name string, wait bool) error
+
+	CreateReservation(name, template string) error
+	DeleteReservation(name string) error
+	DeleteReservationWait(name string, wait bool) error
+
+	CreateInstanceWithJobId(name, template, reservation, jobid, shutdownScript string) error
+	GetInstanceId(name string) (string, error)
+	SetInstanceMetadata(name string, items map[string]string) error
+	SetInstanceLabels(name string, labels map[string]string) error
+	DeleteInstanceWait(name string, wait bool) error
+	InstanceExistWithError(filter string) (bool, error)
+	// DeleteHost deletes the calling VM
+	DeleteHost() error
+}
+
+var _ ComputeProvider = GoogleCompute{}
+
+// FakeInstance is an instance created through FakeCompute
+type FakeInstance struct {
+	Id             string
+	Template       string
+	Reservation    string
+	JobID          string
+	ShutdownScript string
+	Metadata       map[string]string
+	Labels         map[string]string
+}
+
+// FakeCompute keeps Compute Engine objects in memory, standing in for
+// GoogleCompute in tests. Deleting a missing object returns a 404
+// googleapi.Error like Compute Engine does. Specs keeps the spec of every
+// template created. Errors in Fail, keyed by method name, are returned
+// instead of performing the call.
+type FakeCompute struct {
+	mu                      sync.Mutex
+	next                    int
+	Name, Project, Zone, Id string
+	Templates               map[string][]string
+	Specs                   map[string]TemplateSpec
+	Reservations            map[string]string
+	Instances               map[string]FakeInstance
+	Created, Deleted        []string
+	Fail                    map[string]error
+}
+
+// NewFakeCompute returns a fake for the VM name whose own instance exists.
+// An empty name stands for a caller outside Google Compute Engine.
+func NewFakeCompute(name string) *FakeCompute {
+	f := &FakeCompute{
+		Name:         name,
+		Project:      "google-project",
+		Zone:         "us-central1-a",
+		Templates:    map[string][]string{},
+		Specs:        map[string]TemplateSpec{},
+		Reservations: map[string]string{},
+		Instances:    map[string]FakeInstance{},
+		Fail:         map[string]error{},
+	}
+	if len(name) > 0 {
+		f.Id = "1234567890"
+		f.Instances[name] = FakeInstance{Id: f.Id}
+	}
+	return f
+}
+
+func (f *FakeCompute) GetName() string    { return f.Name }
+func (f *FakeCompute) GetProject() string { return f.Project }
+func (f *FakeCompute) GetZone() string    { return f.Zone }
+func (f *FakeCompute) GetId() string      { return f.Id }
+
+func notFound(kind, name string) error {
+	return &googleapi.Error{Code: http.StatusNotFound, Message: kind + " " + name + " not found"}
+}
+
+func (f *FakeCompute) CreateInstanceTemplates(name, serviceAccount string, spec TemplateSpec, container, containerCmd string, containerArgs ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.Fail["CreateInstanceTemplates"]; err != nil {
+		return err
+	}
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	if _, ok := f.Templates[name]; ok {
+		return errors.New("template " + name + " already exists")
+	}
+	f.Specs[name] = spec
+	f.Templates[name] = append([]string{container, containerCmd}, containerArgs...)
+	f.Created = append(f.Created, "template/"+name)
+	return nil
+}
+
+func (f *FakeCompute) DeleteTemplate(name string) error {
+	return f.DeleteTemplateWait(name, true)
+}
+
+func (f *FakeCompute) DeleteTemplateWait(name string, wait bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.Fail["DeleteTemplate"]; err != nil {
+		return err
+	}
+	if _, ok := f.Templates[name]; !ok {
+		return notFound("template", name)
+	}
+	delete(f.Templates, name)
+	f.Deleted = append(f.Deleted, "template/"+name)
+	return nil
+}
+
+func (f *FakeCompute) CreateReservation(name, template string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.Fail["CreateReservation"]; err != nil {
+		return err
+	}
+	if _, ok := f.Templates[template]; !ok {
+		return notFound("template", template)
+	}
+	f.Reservations[name] = template
+	f.Created = append(f.Created, "reservation/"+name)
+	return nil
+}
+
+func (f *FakeCompute) DeleteReservation(name string) error {
+	return f.DeleteReservationWait(name, true)
+}
+
+func (f *FakeCompute) DeleteReservationWait(name string, wait bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.Fail["DeleteReservation"]; err != nil {
+		return err
+	}
+	if _, ok := f.Reservations[name]; !ok {
+		return notFound("reservation", name)
+	}
+	delete(f.Reservations, name)
+	f.Deleted = append(f.Deleted, "reservation/"+name)
+	return nil
+}
+
+func (f *FakeCompute) CreateInstanceWithJobId(name, template, reservation, jobid, shutdownScript string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.Fail["CreateInstanceWithJobId"]; err != nil {
+		return err
+	}
+	if _, ok := f.Templates[template]; !ok {
+		return notFound("template", template)
+	}
+	if _, ok := f.Reservations[reservation]; !ok {
+		return notFound("reservation", reservation)
+	}
+	f.next++
+	f.Instances[name] = FakeInstance{
+		Id:             strconv.Itoa(9000000000 + f.next),
+		Template:       template,
+		Reservation:    reservation,
+		JobID:          jobid,
+		ShutdownScript: shutdownScript,
+		Metadata:       map[string]string{},
+		Labels:         maps.Clone(f.Specs[template].Labels),
+	}
+	f.Created = append(f.Created, "instance/"+name)
+	return nil
+}
+
+func (f *FakeCompute) GetInstanceId(name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.Fail["GetInstanceId"]; err != nil {
+		return "", err
+	}
+	instance, ok := f.Instances[name]
+	if !ok {
+		return "", notFound("instance", name)
+	}
+	return instance.Id, nil
+}
+
+// SetInstanceMetadata records items in the instance Metadata, with the
+// shutdown-script item also stored as ShutdownScript
+func (f *FakeCompute) SetInstanceMetadata(name string, items map[string]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.Fail["SetInstanceMetadata"]; err != nil {
+		return err
+	}
+	instance, ok := f.Instances[name]
+	if !ok {
+		return notFound("instance", name)
+	}
+	if instance.Metadata == nil {
+		instance.Metadata = map[string]string{}
+	}
+	for key, value := range items {
+		instance.Metadata[key] = value
+	}
+	if script, ok := items["shutdown-script"]; ok {
+		instance.ShutdownScript = script
+	}
+	f.Instances[name] = instance
+	return nil
+}
+
+func (f *FakeCompute) SetInstanceLabels(name string, labels map[string]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.Fail["SetInstanceLabels"]; err != nil {
+		return err
+	}
+	instance, ok := f.Instances[name]
+	if !ok {
+		return notFound("instance", name)
+	}
+	if instance.Labels == nil {
+		instance.Labels = map[string]string{}
+	}
+	maps.Copy(instance.Labels, labels)
+	f.Instances[name] = instance
+	return nil
+}
+
+func (f *FakeCompute) DeleteInstanceWait(name string, wait bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.Fail["DeleteInstance"]; err != nil {
+		return err
+	}
+	if _, ok := f.Instances[name]; !ok {
+		return notFound("instance", name)
+	}
+	delete(f.Instances, name)
+	f.Deleted = append(f.Deleted, "instance/"+name)
+	return nil
+}
+
+func (f *FakeCompute) InstanceExistWithError(filter string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.Fail["InstanceExistWithError"]; err != nil {
+		return false, err
+	}
+	_, ok := f.Instances[filter]
+	return ok, nil
+}
+
+func (f *FakeCompute) DeleteHost() error {
+	return f.DeleteInstanceWait(f.Name, true)
+}
+
+// Leaks returns the templates, reservations and instances other than the
+// calling VM that still exist
+func (f *FakeCompute) Leaks() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	leaks := []string{}
+	for name := range f.Templates {
+		leaks = append(leaks, "template/"+name)
+	}
+	for name := range f.Reservations {
+		leaks = append(leaks, "reservation/"+name)
+	}
+	for name := range f.Instances {
+		if name != f.Name {
+			leaks = append(leaks, "instance/"+name)
+		}
+	}
+	sort.Strings(leaks)
+	return leaks
+}
@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package google
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFakeCompute(t *testing.T) {
+	var vm ComputeProvider = NewFakeCompute("batch-vm")
+	fake := vm.(*FakeCompute)
+	if err := vm.CreateReservation("r", "missing"); !IsNotFound(err) {
+		t.Errorf("CreateReservation() without template returned %v", err)
+	}
+	spec := DefaultTemplateSpec()
+	spec.Labels = map[string]string{"team": "genomics"}
+	if err := vm.CreateInstanceTemplates("t", "sa", spec, "image", "/entrypoint", "--job-id", "TEMP_JOB_ID"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.CreateReservation("r", "t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.CreateInstanceWithJobId("i", "t", "r", "555", "#!/bin/bash"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := vm.InstanceExistWithError("i"); !exists {
+		t.Error("InstanceExistWithError() missed the instance")
+	}
+	if id, err := vm.GetInstanceId("i"); err != nil || len(id) == 0 || id == vm.GetId() {
+		t.Errorf("GetInstanceId() returned %q, %v", id, err)
+	}
+	if err := vm.SetInstanceMetadata("i", map[string]string{"shutdown-script": "#!/bin/sh", "jarvice-job-id": "555"}); err != nil {
+		t.Fatal(err)
+	}
+	if instance := fake.Instances["i"]; instance.ShutdownScript != "#!/bin/sh" || instance.Metadata["jarvice-job-id"] != "555" {
+		t.Errorf("SetInstanceMetadata() stored %+v", instance)
+	}
+	if err := vm.SetInstanceMetadata("missing", nil); !IsNotFound(err) {
+		t.Errorf("SetInstanceMetadata() on a missing instance returned %v", err)
+	}
+	if err := vm.SetInstanceLabels("i", map[string]string{"jarvice-job": "555"}); err != nil {
+		t.Fatal(err)
+	}
+	if labels := fake.Instances["i"].Labels; labels["team"] != "genomics" || labels["jarvice-job"] != "555" {
+		t.Errorf("instance labels %v", labels)
+	}
+	if leaks := strings.Join(fake.Leaks(), ","); leaks != "instance/i,reservation/r,template/t" {
+		t.Errorf("Leaks() returned %s", leaks)
+	}
+	fake.Fail["DeleteReservation"] = errors.New("in use")
+	if err := vm.DeleteReservation("r"); err == nil || IsNotFound(err) {
+		t.Errorf("DeleteReservation() returned %v", err)
+	}
+	delete(fake.Fail, "DeleteReservation")
+	for _, err := range []error{vm.DeleteInstanceWait("i", false), vm.DeleteReservation("r"), vm.DeleteTemplate("t")} {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if err := vm.DeleteTemplate("t"); !IsNotFound(err) {
+		t.Errorf("second DeleteTemplate() returned %v", err)
+	}
+	if len(fake.Leaks()) != 0 || len(fake.Deleted) != 3 {
+		t.Errorf("Leaks() %v, Deleted %v", fake.Leaks(), fake.Deleted)
+	}
+	if err := vm.DeleteHost(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := vm.InstanceExistWithError("batch-vm"); exists {
+		t.Error("DeleteHost() left the VM")
+	}
+}
+
+func TestNewGoogleComputeWithConfig(t *testing.T) {
+	vm, err := NewGoogleComputeWithConfig("google-project", "us-central1-a", "", "projects/google-project/regions/us-central1/subnetworks/dragen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.GetProject() != "google-project" || vm.GetZone() != "us-central1-a" || vm.GetName() != "" || vm.GetId() != "" {
+		t.Errorf("NewGoogleComputeWithConfig() returned %+v", vm)
+	}
+	if subnet, err := vm.subnetwork("us-central1"); err != nil || subnet != "projects/google-project/regions/us-central1/subnetworks/dragen" || vm.network != "default" {
+		t.Errorf("subnetwork() returned %q, %v with network %q", subnet, err, vm.network)
+	}
+	if _, err := NewGoogleComputeWithConfig("google-project", "", "", ""); err == nil {
+		t.Error("NewGoogleComputeWithConfig() accepted a missing zone")
+	}
+}
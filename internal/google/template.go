@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package google
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"jarvice.io/dragen/config"
+)
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValuePattern = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+	labelInvalid      = regexp.MustCompile(`[^a-z0-9_-]`)
+	tagPattern        = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	kmsKeyPattern     = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+(/cryptoKeyVersions/[^/]+)?$`)
+)
+
+// TemplateSpec shapes the meter VM created from the container template.
+// DiskType and DiskSizeGb describe the data disk next to the DRAGEN boot
+// image, KMSKey encrypts both disks with a customer managed key. Without an
+// external IP the subnet needs Private Google Access or Cloud NAT.
+type TemplateSpec struct {
+	MachineType       string            `json:"machineType,omitempty"`
+	DiskType          string            `json:"diskType,omitempty"`
+	DiskSizeGb        int64             `json:"diskSizeGb,omitempty"`
+	Subnet            string            `json:"subnet,omitempty"`
+	Tags              []string          `json:"tags,omitempty"`
+	NoExternalIP      bool              `json:"noExternalIp,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	KMSKey            string            `json:"kmsKey,omitempty"`
+	MaintenancePolicy string            `json:"maintenancePolicy,omitempty"`
+}
+
+func DefaultTemplateSpec() TemplateSpec {
+	return TemplateSpec{
+		MachineType:       config.GoogleMachine,
+		DiskType:          "pd-balanced",
+		DiskSizeGb:        10,
+		MaintenancePolicy: "MIGRATE",
+	}
+}
+
+// LoadTemplateSpec reads a JSON TemplateSpec over the defaults, rejecting
+// unknown fields
+func LoadTemplateSpec(path string) (TemplateSpec, error) {
+	spec := DefaultTemplateSpec()
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return spec, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(blob))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		return spec, fmt.Errorf("template spec %s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// Validate checks the spec against the Compute Engine naming rules so that
+// mistakes are reported before any object is created
+func (spec TemplateSpec) Validate() error {
+	errs := []error{}
+	if len(spec.MachineType) == 0 {
+		errs = append(errs, errors.New("missing machine type"))
+	}
+	if len(spec.DiskType) == 0 {
+		errs = append(errs, errors.New("missing disk type"))
+	}
+	if spec.DiskSizeGb < 1 {
+		errs = append(errs, fmt.Errorf("invalid disk size %d GB", spec.DiskSizeGb))
+	}
+	if spec.MaintenancePolicy != "MIGRATE" && spec.MaintenancePolicy != "TERMINATE" {
+		errs = append(errs, errors.New("maintenance policy must be MIGRATE or TERMINATE, got "+spec.MaintenancePolicy))
+	}
+	for _, tag := range spec.Tags {
+		if !tagPattern.MatchString(tag) {
+			errs = append(errs, errors.New("invalid network tag "+tag))
+		}
+	}
+	for key, value := range spec.Labels {
+		if !labelKeyPattern.MatchString(key) || !labelValuePattern.MatchString(value) {
+			errs = append(errs, errors.New("invalid label "+key+"="+value))
+		}
+	}
+	if len(spec.KMSKey) > 0 && !kmsKeyPattern.MatchString(spec.KMSKey) {
+		errs = append(errs, errors.New("KMS key must be projects/PROJECT/locations/LOCATION/keyRings/RING/cryptoKeys/KEY, got "+spec.KMSKey))
+	}
+	return errors.Join(errs...)
+}
+
+// LabelValue turns value into a valid label value by lower casing it,
+// replacing unsupported characters with dashes and truncating it to 63
+// characters
+func LabelValue(value string) string {
+	value = labelInvalid.ReplaceAllString(strings.ToLower(value), "-")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return value
+}
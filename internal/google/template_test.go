@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package google
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTemplateSpec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spec.json")
+	os.WriteFile(path, []byte(`{"machineType": "e2-small", "noExternalIp": true, "tags": ["dragen"],
+		"labels": {"team": "genomics"}}`), 0644)
+	spec, err := LoadTemplateSpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.MachineType != "e2-small" || !spec.NoExternalIP || spec.Tags[0] != "dragen" || spec.Labels["team"] != "genomics" {
+		t.Errorf("LoadTemplateSpec() returned %+v", spec)
+	}
+	// defaults for the fields not in the file
+	if spec.DiskType != "pd-balanced" || spec.DiskSizeGb != 10 || spec.MaintenancePolicy != "MIGRATE" {
+		t.Errorf("LoadTemplateSpec() returned %+v", spec)
+	}
+
+	os.WriteFile(path, []byte(`{"machine": "e2-small"}`), 0644)
+	if _, err := LoadTemplateSpec(path); err == nil || !strings.Contains(err.Error(), "machine") {
+		t.Errorf("LoadTemplateSpec() accepted an unknown field: %v", err)
+	}
+}
+
+func TestTemplateSpecValidate(t *testing.T) {
+	if err := DefaultTemplateSpec().Validate(); err != nil {
+		t.Errorf("default spec invalid: %s", err.Error())
+	}
+	spec := DefaultTemplateSpec()
+	spec.KMSKey = "projects/p/locations/us-central1/keyRings/dragen/cryptoKeys/disk"
+	spec.Labels = map[string]string{"team": "genomics", "cost-center": ""}
+	spec.Tags = []string{"allow-nat", "dragen-1"}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("Validate() failed: %s", err.Error())
+	}
+	spec = TemplateSpec{
+		MaintenancePolicy: "LIVE",
+		Tags:              []string{"Dragen"},
+		Labels:            map[string]string{"Team": "x"},
+		KMSKey:            "disk-key",
+	}
+	err := spec.Validate()
+	if err == nil {
+		t.Fatal("Validate() accepted an invalid spec")
+	}
+	for _, problem := range []string{"machine type", "disk type", "disk size", "maintenance policy", "network tag Dragen", "label Team=x", "KMS key"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Validate() did not report %s: %s", problem, err.Error())
+		}
+	}
+}
+
+func TestContainerTemplateSpec(t *testing.T) {
+	vm := GoogleCompute{project: "google-project", zone: "us-central1-a", network: "default"}
+	spec := TemplateSpec{
+		MachineType:       "e2-small",
+		DiskType:          "pd-ssd",
+		DiskSizeGb:        20,
+		Tags:              []string{"allow-nat"},
+		NoExternalIP:      true,
+		Labels:            map[string]string{"team": "genomics"},
+		KMSKey:            "projects/kms/locations/us-central1/keyRings/dragen/cryptoKeys/disk",
+		MaintenancePolicy: "TERMINATE",
+	}
+	subnet := "projects/host-project/regions/us-central1/subnetworks/shared"
+	properties := vm.containerTemplate("dragen-abc", "sa", "us-central1", subnet, spec, "meter:1.0", "/entrypoint").Properties
+	if properties.GetMachineType() != "e2-small" || properties.Scheduling.GetOnHostMaintenance() != "TERMINATE" {
+		t.Errorf("containerTemplate() returned %v", properties)
+	}
+	data := properties.Disks[1].InitializeParams
+	if data.GetDiskType() != "pd-ssd" || data.GetDiskSizeGb() != 20 {
+		t.Errorf("containerTemplate() data disk %v", data)
+	}
+	for _, disk := range properties.Disks {
+		if disk.DiskEncryptionKey.GetKmsKeyName() != spec.KMSKey {
+			t.Errorf("containerTemplate() disk %s not encrypted with the KMS key", disk.GetDeviceName())
+		}
+	}
+	nic := properties.NetworkInterfaces[0]
+	if len(nic.AccessConfigs) > 0 {
+		t.Error("containerTemplate() added an external IP")
+	}
+	if nic.GetSubnetwork() != subnet || nic.Network != nil {
+		t.Errorf("containerTemplate() network %q subnetwork %q", nic.GetNetwork(), nic.GetSubnetwork())
+	}
+	if properties.Tags.Items[0] != "allow-nat" || properties.Labels["team"] != "genomics" || properties.Labels["container-vm"] == "" {
+		t.Errorf("containerTemplate() tags %v labels %v", properties.Tags, properties.Labels)
+	}
+
+	// defaults keep the previous shape
+	properties = vm.containerTemplate("dragen-abc", "sa", "us-central1", "default", DefaultTemplateSpec(), "meter:1.0", "/entrypoint").Properties
+	if len(properties.NetworkInterfaces[0].AccessConfigs) != 1 || properties.Disks[0].DiskEncryptionKey != nil || properties.Tags != nil {
+		t.Errorf("containerTemplate() with defaults returned %v", properties)
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	for value, expected := range map[string]string{
+		"NA12878":               "na12878",
+		"1.4.0+build":           "1-4-0-build",
+		"dragen_sample-1":       "dragen_sample-1",
+		strings.Repeat("a", 70): strings.Repeat("a", 63),
+	} {
+		if label := LabelValue(value); label != expected {
+			t.Errorf("LabelValue(%q) returned %q, expected %q", value, label, expected)
+		}
+	}
+}
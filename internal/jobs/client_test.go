@@ -0,0 +1,162 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package jobs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func clientServer(handler http.HandlerFunc) (*httptest.Server, *Client) {
+	ts := httptest.NewUnstartedServer(handler)
+	go ts.Start()
+	client := NewClient("http://"+ts.Listener.Addr().String(), nil)
+	client.MinBackoff = time.Millisecond
+	client.MaxBackoff = 5 * time.Millisecond
+	return ts, client
+}
+
+func TestClientRetry(t *testing.T) {
+	var calls int32
+	ts, client := clientServer(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	defer ts.Close()
+	body, err := client.PostForm(context.Background(), "/jarvice/status", nil)
+	if err != nil || string(body) != "ok" {
+		t.Errorf("PostForm() failed: %v", err)
+	}
+	if atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("expected 3 calls, got %d", atomic.LoadInt32(&calls))
+	}
+}
+
+func TestClientRetryExhausted(t *testing.T) {
+	var calls int32
+	ts, client := clientServer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	defer ts.Close()
+	client.Retries = 2
+	_, err := client.PostForm(context.Background(), "/jarvice/status", nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected HTTP 429 APIError, got %v", err)
+	}
+	if atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("expected 3 calls, got %d", atomic.LoadInt32(&calls))
+	}
+}
+
+func TestClientNoRetryClientError(t *testing.T) {
+	var calls int32
+	ts, client := clientServer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	defer ts.Close()
+	if _, err := client.PostForm(context.Background(), "/jarvice/machines", nil); err == nil {
+		t.Error("expected error for HTTP 401")
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("expected 1 call, got %d", atomic.LoadInt32(&calls))
+	}
+}
+
+func TestClientSubmitNoRetryServerError(t *testing.T) {
+	var calls int32
+	ts, client := clientServer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Error("PostJSON() did not set Content-Type")
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer ts.Close()
+	if _, err := client.PostJSON(context.Background(), "/jarvice/submit", map[string]string{}); err == nil {
+		t.Error("expected error for HTTP 500")
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("submit must not be retried after HTTP 500, got %d calls", atomic.LoadInt32(&calls))
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	var calls int32
+	ts, client := clientServer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	defer ts.Close()
+	client.Timeout = 20 * time.Millisecond
+	client.Retries = 1
+	start := time.Now()
+	if _, err := client.PostForm(context.Background(), "/jarvice/tail", nil); err == nil {
+		t.Error("expected timeout error")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("per-call timeout not enforced")
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("expected 2 calls, got %d", atomic.LoadInt32(&calls))
+	}
+}
+
+func TestClientContextCanceled(t *testing.T) {
+	ts, client := clientServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer ts.Close()
+	client.MinBackoff = time.Hour
+	client.MaxBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.PostForm(ctx, "/jarvice/status", nil); err == nil {
+		t.Error("expected error after context cancellation")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("backoff did not honor context cancellation")
+	}
+}
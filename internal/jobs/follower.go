@@ -0,0 +1,209 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package jobs
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"jarvice.io/dragen/internal/logger"
+)
+
+// LineSink receives each job output line once
+type LineSink interface {
+	WriteLine(line string) error
+}
+
+type writerSink struct {
+	w io.Writer
+}
+
+func (s writerSink) WriteLine(line string) error {
+	_, err := io.WriteString(s.w, line+"\n")
+	return err
+}
+
+func WriterSink(w io.Writer) LineSink {
+	return writerSink{w: w}
+}
+
+type loggerSink struct {
+	logger *slog.Logger
+	number string
+}
+
+func (s loggerSink) WriteLine(line string) error {
+	s.logger.Info(line, "job", s.number)
+	return nil
+}
+
+// LoggerSink writes each line as a structured record tagged with the job number
+func LoggerSink(logger *slog.Logger, number string) LineSink {
+	return loggerSink{logger: logger, number: number}
+}
+
+// LogFollower turns successive tails of a job log into a stream of new
+// lines. Each tail is matched against the previous one by finding the
+// longest suffix of the previous tail that is a prefix of the new tail, so
+// windows of any size and bursts of output are handled. A trailing line
+// without a newline is held back until it is complete or Flush is called.
+//
+// Tails do not say how many lines were added, so the match is ambiguous
+// when a shorter overlap fits as well, e.g. when identical progress lines
+// repeat. The larger overlap is used so lines are never emitted twice, at
+// the cost of dropping repeated lines that arrived between polls. Such
+// matches are counted and the first one is logged.
+type LogFollower struct {
+	mu        sync.Mutex
+	sinks     []LineSink
+	last      []string
+	partial   string
+	flushed   string
+	ambiguous int
+}
+
+func NewLogFollower(sinks ...LineSink) *LogFollower {
+	return &LogFollower{sinks: sinks}
+}
+
+func (f *LogFollower) SetSinks(sinks ...LineSink) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sinks = sinks
+}
+
+func (f *LogFollower) AddSink(sink LineSink) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sinks = append(f.sinks, sink)
+}
+
+// Update processes a new tail of the log and returns the number of lines
+// emitted. Sink errors are returned after every sink received every line.
+func (f *LogFollower) Update(tail string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if tail == "" {
+		// keep the previous tail to match against
+		return 0, nil
+	}
+	lines := strings.Split(tail, "\n")
+	partial := lines[len(lines)-1]
+	lines = lines[:len(lines)-1]
+
+	overlap, ambiguous := suffixPrefixOverlap(f.last, lines)
+	if overlap == 0 && len(f.last) > 0 && len(lines) > 0 {
+		logger.Ologger.Warn("job output skipped lines between polls")
+	}
+	if ambiguous {
+		if f.ambiguous == 0 {
+			logger.Ologger.Warn("job output repeats across polls, identical lines may be missing")
+		}
+		f.ambiguous += 1
+	}
+	fresh := lines[overlap:]
+	if f.flushed != "" {
+		if len(fresh) > 0 && fresh[0] == f.flushed {
+			fresh = fresh[1:]
+			f.flushed = ""
+		} else if partial == f.flushed {
+			partial = ""
+		} else {
+			f.flushed = ""
+		}
+	}
+	f.last = lines
+	f.partial = partial
+	return len(fresh), f.emit(fresh)
+}
+
+// Flush emits a held back partial line, e.g. once the job ended
+func (f *LogFollower) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.partial == "" {
+		return nil
+	}
+	line := f.partial
+	f.partial = ""
+	f.flushed = line
+	return f.emit([]string{line})
+}
+
+func (f *LogFollower) emit(lines []string) error {
+	errs := []error{}
+	for _, line := range lines {
+		for _, sink := range f.sinks {
+			if err := sink.WriteLine(line); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// suffixPrefixOverlap returns the length of the longest suffix of prev that
+// is also a prefix of next (Knuth-Morris-Pratt over lines), and whether a
+// shorter non-empty overlap exists as well
+func suffixPrefixOverlap(prev, next []string) (int, bool) {
+	if len(prev) == 0 || len(next) == 0 {
+		return 0, false
+	}
+	failure := make([]int, len(next))
+	for i, k := 1, 0; i < len(next); i++ {
+		for k > 0 && next[i] != next[k] {
+			k = failure[k-1]
+		}
+		if next[i] == next[k] {
+			k += 1
+		}
+		failure[i] = k
+	}
+	start := 0
+	if len(prev) > len(next) {
+		start = len(prev) - len(next)
+	}
+	k := 0
+	for _, line := range prev[start:] {
+		for k > 0 && (k == len(next) || line != next[k]) {
+			k = failure[k-1]
+		}
+		if line == next[k] {
+			k += 1
+		}
+	}
+	// the shorter overlaps are the borders of next[:k]
+	return k, k > 0 && failure[k-1] > 0
+}
@@ -0,0 +1,233 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package jobs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type recordSink struct {
+	lines []string
+}
+
+func (s *recordSink) WriteLine(line string) error {
+	s.lines = append(s.lines, line)
+	return nil
+}
+
+type failSink struct{}
+
+func (s failSink) WriteLine(line string) error {
+	return errors.New("sink failed")
+}
+
+func tailOf(lines []string, window int) string {
+	start := 0
+	if len(lines) > window {
+		start = len(lines) - window
+	}
+	if start == len(lines) {
+		return ""
+	}
+	return strings.Join(lines[start:], "\n") + "\n"
+}
+
+func TestLogFollower(t *testing.T) {
+	sink := &recordSink{}
+	follower := NewLogFollower(sink)
+	log := []string{}
+	for i := 0; i < 20; i++ {
+		log = append(log, fmt.Sprintf("line %d", i))
+		follower.Update(tailOf(log, 5))
+	}
+	// burst smaller than the window
+	for i := 20; i < 24; i++ {
+		log = append(log, fmt.Sprintf("line %d", i))
+	}
+	if n, _ := follower.Update(tailOf(log, 5)); n != 4 {
+		t.Errorf("expected 4 new lines, got %d", n)
+	}
+	// unchanged tail
+	if n, _ := follower.Update(tailOf(log, 5)); n != 0 {
+		t.Errorf("expected no new lines, got %d", n)
+	}
+	if strings.Join(sink.lines, ",") != strings.Join(log, ",") {
+		t.Errorf("LogFollower emitted %v", sink.lines)
+	}
+}
+
+func TestLogFollowerPartialLine(t *testing.T) {
+	sink := &recordSink{}
+	follower := NewLogFollower(sink)
+	follower.Update("a\nb\nprogress 10%")
+	follower.Update("a\nb\nprogress 10% 20%\nc\n")
+	follower.Update("b\nprogress 10% 20%\nc\nend")
+	follower.Flush()
+	follower.Flush()
+	follower.Update("progress 10% 20%\nc\nend\n")
+	expected := "a,b,progress 10% 20%,c,end"
+	if strings.Join(sink.lines, ",") != expected {
+		t.Errorf("LogFollower emitted %v", sink.lines)
+	}
+}
+
+func TestLogFollowerRepeatedLines(t *testing.T) {
+	sink := &recordSink{}
+	follower := NewLogFollower(sink)
+	follower.Update("x\nx\n")
+	follower.Update("x\nx\nx\ny\n")
+	if strings.Join(sink.lines, ",") != "x,x,x,y" {
+		t.Errorf("LogFollower emitted %v", sink.lines)
+	}
+}
+
+func TestLogFollowerEmptyTail(t *testing.T) {
+	sink := &recordSink{}
+	follower := NewLogFollower(sink)
+	follower.Update("a\nb\n")
+	follower.Update("")
+	follower.Update("a\nb\nc\n")
+	if strings.Join(sink.lines, ",") != "a,b,c" {
+		t.Errorf("LogFollower emitted %v", sink.lines)
+	}
+}
+
+func TestLogFollowerSinks(t *testing.T) {
+	var plain, structured bytes.Buffer
+	sink := &recordSink{}
+	follower := NewLogFollower(WriterSink(&plain), failSink{})
+	follower.AddSink(LoggerSink(slog.New(slog.NewTextHandler(&structured, nil)), "555"))
+	follower.AddSink(sink)
+	if _, err := follower.Update("hello\nworld\n"); err == nil {
+		t.Error("sink error not reported")
+	}
+	if plain.String() != "hello\nworld\n" {
+		t.Errorf("WriterSink wrote %q", plain.String())
+	}
+	if !strings.Contains(structured.String(), "msg=world job=555") {
+		t.Errorf("LoggerSink wrote %q", structured.String())
+	}
+	if len(sink.lines) != 2 {
+		t.Error("failing sink blocked other sinks")
+	}
+}
+
+func TestSuffixPrefixOverlap(t *testing.T) {
+	cases := []struct {
+		prev, next string
+		overlap    int
+		ambiguous  bool
+	}{
+		{"", "a b", 0, false},
+		{"a b", "", 0, false},
+		{"a b c", "b c d", 2, false},
+		{"a b c", "c d", 1, false},
+		{"a b c", "d e", 0, false},
+		{"a b a b", "a b a b c", 4, true},
+		{"a a a", "a a", 2, true},
+		{"a b a", "a b a", 3, true},
+		{"c a b a", "a b a x", 3, true},
+		{"a b c d e", "b c", 0, false},
+		{"x a b", "a b c", 2, false},
+	}
+	for _, c := range cases {
+		overlap, ambiguous := suffixPrefixOverlap(strings.Fields(c.prev), strings.Fields(c.next))
+		if overlap != c.overlap || ambiguous != c.ambiguous {
+			t.Errorf("suffixPrefixOverlap(%q, %q) = %d, %t, expected %d, %t",
+				c.prev, c.next, overlap, ambiguous, c.overlap, c.ambiguous)
+		}
+	}
+}
+
+func TestLogFollowerAmbiguous(t *testing.T) {
+	sink := &recordSink{}
+	follower := NewLogFollower(sink)
+	follower.Update("progress\nprogress\nprogress\n")
+	// one more identical line in a three line window looks like no output
+	if n, _ := follower.Update("progress\nprogress\nprogress\n"); n != 0 || follower.ambiguous != 1 {
+		t.Errorf("expected an ambiguous match without lines, got %d lines, %d ambiguous", n, follower.ambiguous)
+	}
+}
+
+// FuzzLogFollower replays a log of few distinct lines through sliding tail
+// windows of varying step sizes. Every update must emit the newest of the
+// lines actually added, never more than were added, and may only drop lines
+// on a match reported as ambiguous. Without ambiguous matches or gaps the
+// whole log is emitted.
+func FuzzLogFollower(f *testing.F) {
+	f.Add([]byte("hello world\nfoo\n\nbar"), uint8(5), []byte{1, 2, 3})
+	f.Add([]byte("a\na\na\na\na\na"), uint8(2), []byte{1, 1, 7})
+	f.Add([]byte(""), uint8(1), []byte{0})
+	f.Add([]byte("x\ny\nz\nx\ny\nz\nx"), uint8(3), []byte{4, 2, 9, 1})
+	f.Add([]byte{0, 0, 1, 0, 0, 0, 2, 2, 2, 2, 1}, uint8(4), []byte{0, 1, 2})
+	f.Fuzz(func(t *testing.T, data []byte, window uint8, steps []byte) {
+		if window == 0 || len(steps) == 0 {
+			return
+		}
+		// three distinct lines make repeated lines and blocks common
+		log := make([]string, len(data))
+		for i, b := range data {
+			log[i] = string(rune('a' + b%3))
+		}
+		sink := &recordSink{}
+		follower := NewLogFollower(sink)
+		end, gaps := 0, false
+		for i := 0; end < len(log); i++ {
+			step := int(steps[i%len(steps)])%16 + 1
+			if step >= int(window) {
+				gaps = true
+			}
+			prev := end
+			end += step
+			if end > len(log) {
+				end = len(log)
+			}
+			ambiguous := follower.ambiguous
+			n, _ := follower.Update(tailOf(log[:end], int(window)))
+			added := end - prev
+			emitted := strings.Join(sink.lines[len(sink.lines)-n:], ",")
+			if n > added || emitted != strings.Join(log[end-n:end], ",") {
+				t.Fatalf("emitted %q for the added lines %q", emitted, log[prev:end])
+			}
+			if step < int(window) && n < added && follower.ambiguous == ambiguous {
+				t.Fatalf("dropped %d of %q without an ambiguous match", added-n, log[prev:end])
+			}
+		}
+		if !gaps && follower.ambiguous == 0 && strings.Join(sink.lines, ",") != strings.Join(log, ",") {
+			t.Fatalf("emitted %d of %d lines", len(sink.lines), len(log))
+		}
+	})
+}
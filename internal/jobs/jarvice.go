@@ -31,60 +31,74 @@ policies, either expressed or implied, of Nimbix, Inc.
 package jobs
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"jarvice.io/dragen/internal/logger"
 )
 
-const tailLength = 100
-const compareWindow = 25
+const DefaultTerminateTimeout = 5 * time.Minute
 
-func Max(x, y int) int {
-	if x < y {
-		return y
-	}
-	return x
-}
+var terminatePollInterval = 10 * time.Second
 
-func Min(x, y int) int {
-	if x < y {
-		return x
-	}
-	return y
+type JobStatus struct {
+	Status     string      `json:"job_status"`
+	SubmitTime int64       `json:"job_submit_time,omitempty"`
+	StartTime  int64       `json:"job_start_time,omitempty"`
+	EndTime    int64       `json:"job_end_time,omitempty"`
+	Walltime   string      `json:"job_walltime,omitempty"`
+	ExitCode   json.Number `json:"job_exitcode,omitempty"`
 }
 
-type JobStatus struct {
-	Status string `json:"job_status"`
+// JobInfo holds the details JARVICE reports for a finished job. ExitCode is
+// nil when JARVICE did not report the application exit code.
+type JobInfo struct {
+	Number     string
+	State      JobState
+	ExitCode   *int
+	SubmitTime time.Time
+	StartTime  time.Time
+	EndTime    time.Time
+	Runtime    time.Duration
 }
 
 type JobStatusList map[string]JobStatus
 
 type JarviceJob struct {
-	apiHost, Number string
-	values          url.Values
-	lastTail        []string
+	Number    string
+	client    *Client
+	values    url.Values
+	follower  *LogFollower
+	lastState *JobState
 }
 
 func NewJarviceJob(apiHost, username, apikey, number string) *JarviceJob {
+	return NewJarviceJobWithClient(NewClient(apiHost, nil), username, apikey, number)
+}
+
+func NewJarviceJobWithClient(client *Client, username, apikey, number string) *JarviceJob {
 	return &JarviceJob{
-		apiHost: strings.TrimSuffix(apiHost, "/"),
-		Number:  number,
+		client: client,
+		Number: number,
 		values: url.Values{
 			"username": {username},
 			"apikey":   {apikey},
 			"number":   {number},
 		},
-		lastTail: make([]string, tailLength),
+		follower:  NewLogFollower(WriterSink(os.Stdout)),
+		lastState: new(JobState),
 	}
 }
 
-func (job JarviceJob) CheckAuth() bool {
+func (job JarviceJob) CheckAuth(ctx context.Context) bool {
 
 	values := url.Values{}
 	for k, v := range job.values {
@@ -92,20 +106,15 @@ func (job JarviceJob) CheckAuth() bool {
 	}
 
 	values.Del("number")
-	resp, err := http.PostForm(job.apiHost+"/jarvice/machines", values)
-	if err != nil {
+	if _, err := job.client.PostForm(ctx, "/jarvice/machines", values); err != nil {
 		logger.Ologger.Warn(err.Error())
 		return false
 	}
-
-	if resp.StatusCode == http.StatusOK {
-		return true
-	}
-	return false
+	return true
 }
 
-func (job JarviceJob) Running() bool {
-	if ret, err := job.RunningWithError(); err != nil {
+func (job JarviceJob) Running(ctx context.Context) bool {
+	if ret, err := job.RunningWithError(ctx); err != nil {
 		logger.Ologger.Warn(err.Error())
 		return false
 	} else {
@@ -113,32 +122,22 @@ func (job JarviceJob) Running() bool {
 	}
 }
 
-func (job JarviceJob) RunningWithError() (bool, error) {
-
-	resp, err := http.PostForm(job.apiHost+"/jarvice/status", job.values)
+func (job JarviceJob) RunningWithError(ctx context.Context) (bool, error) {
+	state, _, err := job.Status(ctx)
 	if err != nil {
 		return false, err
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return false, errors.New("Cannot find JARVICE job " + job.Number)
-	}
-
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-	jobStatusList := JobStatusList{}
-	json.Unmarshal(body, &jobStatusList)
-	if jobStatusList[job.Number].Status == "PROCESSING STARTING" {
+	switch state {
+	case StateProcessingStarting:
 		return true, nil
-	} else if jobStatusList[job.Number].Status == "SUBMITTED" {
+	case StateSubmitted:
 		return false, nil
-	} else {
-		return false, errors.New("JARVICE job not found")
 	}
+	return false, fmt.Errorf("JARVICE job %s %s", job.Number, state)
 }
 
-func (job JarviceJob) ExitSuccess() bool {
-	if ret, err := job.ExitSuccessWithError(); err != nil {
+func (job JarviceJob) ExitSuccess(ctx context.Context) bool {
+	if ret, err := job.ExitSuccessWithError(ctx); err != nil {
 		logger.Ologger.Warn(err.Error())
 		return false
 	} else {
@@ -146,105 +145,189 @@ func (job JarviceJob) ExitSuccess() bool {
 	}
 }
 
-func (job JarviceJob) ExitSuccessWithError() (bool, error) {
-
-	resp, err := http.PostForm(job.apiHost+"/jarvice/status", job.values)
+func (job JarviceJob) ExitSuccessWithError(ctx context.Context) (bool, error) {
+	state, _, err := job.Status(ctx)
 	if err != nil {
 		return false, err
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return false, errors.New("Cannot find JARVICE job " + job.Number)
-	}
-
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-	jobStatusList := JobStatusList{}
-	json.Unmarshal(body, &jobStatusList)
-	if jobStatusList[job.Number].Status == "COMPLETED" {
+	if state.Success() {
 		return true, nil
-	} else {
-		return false, errors.New("JARVICE job failed")
 	}
+	return false, fmt.Errorf("JARVICE job %s failed: %s", job.Number, state)
 }
 
-func (job JarviceJob) GetJobOutput() {
-	defer func() {
-		recover()
-	}()
-	resp, err := http.PostForm(job.apiHost+"/jarvice/tail", job.values)
+// Status returns the parsed job state along with the raw JSON status entry
+// for the job
+func (job JarviceJob) Status(ctx context.Context) (JobState, json.RawMessage, error) {
+
+	body, err := job.client.PostForm(ctx, "/jarvice/status", job.values)
 	if err != nil {
-		// best effort
-		return
-	} else if resp.StatusCode != 200 {
-		// best effort
-		return
-	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-	outputLines := strings.Split(string(body), "\n")
-
-	lastRealLine := ""
-	lastRealIndex := 0
-	for i := len(job.lastTail) - 1; i >= 0; i-- {
-		if len(job.lastTail[i]) > 0 {
-			lastRealIndex = Max(i-compareWindow, 0)
-			break
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return StateUnknown, nil, fmt.Errorf("%w: %s (HTTP %d)", ErrJobNotFound, job.Number, apiErr.StatusCode)
 		}
+		return StateUnknown, nil, err
 	}
-	for _, line := range job.lastTail[lastRealIndex:] {
-		if len(line) > 0 {
-			lastRealLine = line
-			break
-		}
-		lastRealIndex += 1
-	}
-	startIndex := 0
-	linesMatch := 0
-	if lastRealIndex < tailLength-1 {
-		for index, line := range outputLines {
-			if line == lastRealLine {
-				startIndex = index
-				for _, tailLine := range job.lastTail[lastRealIndex:] {
-					if startIndex >= len(outputLines) {
-						startIndex = -1
-						break
-					} else if outputLines[startIndex] != tailLine {
-						break
-					} else {
-						linesMatch += 1
-						startIndex += 1
-					}
-				}
-				if linesMatch == (tailLength - lastRealIndex) {
-					break
-				} else {
-					linesMatch = 0
-				}
-			}
+	rawList := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &rawList); err != nil {
+		return StateUnknown, nil, err
+	}
+	raw, ok := rawList[job.Number]
+	if !ok {
+		return StateUnknown, nil, fmt.Errorf("%w: %s", ErrJobNotFound, job.Number)
+	}
+	// only the state is decoded here, see Info for the job details
+	jobStatus := struct {
+		Status string `json:"job_status"`
+	}{}
+	if err := json.Unmarshal(raw, &jobStatus); err != nil {
+		return StateUnknown, raw, err
+	}
+	state, err := ParseJobState(jobStatus.Status)
+	if err != nil {
+		return StateUnknown, raw, err
+	}
+	if job.lastState != nil {
+		if err := ValidateTransition(*job.lastState, state); err != nil {
+			logger.Ologger.Warn(err.Error(), "job", job.Number)
 		}
+		*job.lastState = state
+	}
+	return state, raw, nil
+}
+
+// Info returns the job state along with its exit code, start and end time,
+// and runtime
+func (job JarviceJob) Info(ctx context.Context) (JobInfo, error) {
+	info := JobInfo{Number: job.Number}
+	state, raw, err := job.Status(ctx)
+	if err != nil {
+		return info, err
 	}
-	if startIndex >= 0 {
-		for _, line := range outputLines[startIndex:] {
-			fmt.Println(line)
+	info.State = state
+	jobStatus := JobStatus{}
+	if err := json.Unmarshal(raw, &jobStatus); err != nil {
+		return info, fmt.Errorf("JARVICE job %s details: %w", job.Number, err)
+	}
+	if code, err := strconv.Atoi(jobStatus.ExitCode.String()); err == nil {
+		info.ExitCode = &code
+	}
+	info.SubmitTime = unixTime(jobStatus.SubmitTime)
+	info.StartTime = unixTime(jobStatus.StartTime)
+	info.EndTime = unixTime(jobStatus.EndTime)
+	if !info.StartTime.IsZero() && info.EndTime.After(info.StartTime) {
+		info.Runtime = info.EndTime.Sub(info.StartTime)
+	} else if walltime, err := parseWalltime(jobStatus.Walltime); err == nil {
+		info.Runtime = walltime
+	}
+	return info, nil
+}
+
+func unixTime(seconds int64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// parseWalltime parses the HH:MM:SS walltime reported by JARVICE
+func parseWalltime(walltime string) (time.Duration, error) {
+	parts := strings.Split(walltime, ":")
+	if len(parts) != 3 {
+		return 0, errors.New("invalid walltime " + walltime)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 {
+			return 0, errors.New("invalid walltime " + walltime)
 		}
+		d += time.Duration(n) * unit
 	}
-	tailIndex := tailLength - 1
-	for i := len(outputLines) - 1; i >= Max(len(outputLines)-tailLength, 0); i-- {
-		job.lastTail[tailIndex] = outputLines[i]
-		tailIndex -= 1
+	return d, nil
+}
+
+// SetLogSinks replaces the destinations of GetJobOutput (stdout by default)
+func (job JarviceJob) SetLogSinks(sinks ...LineSink) {
+	job.follower.SetSinks(sinks...)
+}
+
+// GetJobOutput sends new job output to the log sinks and returns the number
+// of new lines. An error means the output could not be retrieved.
+func (job JarviceJob) GetJobOutput(ctx context.Context) (int, error) {
+	body, err := job.client.PostForm(ctx, "/jarvice/tail", job.values)
+	if err != nil {
+		return 0, fmt.Errorf("JARVICE job %s output: %w", job.Number, err)
 	}
+	lines, err := job.follower.Update(string(body))
+	if err != nil {
+		logger.Ologger.Warn(err.Error())
+	}
+	return lines, nil
+}
 
-	return
+// FlushJobOutput sends a trailing partial output line to the log sinks
+func (job JarviceJob) FlushJobOutput() {
+	if err := job.follower.Flush(); err != nil {
+		logger.Ologger.Warn(err.Error())
+	}
 }
 
-func (job JarviceJob) Terminate() {
+func (job JarviceJob) Terminate(ctx context.Context) {
 	logger.Ologger.Info("Terminating job " + job.Number + " (best effort)")
-	http.PostForm(job.apiHost+"/jarvice/terminate", job.values)
+	if _, err := job.client.PostForm(ctx, "/jarvice/terminate", job.values); err != nil {
+		logger.Ologger.Warn(err.Error())
+	}
 	return
 }
 
-func (job JarviceJob) GoogleShutdownScript() string {
-	return "#!/bin/bash \ncurl \"" + job.apiHost + "/jarvice/terminate?username=" + job.values.Get("username") +
-		"&apikey=" + job.values.Get("apikey") + "&number=" + job.values.Get("number") + "\""
+// TerminateAndWait terminates the job and polls its status until it ends,
+// sending the terminate request again on every poll the job is not known to
+// have ended. The first request goes out before any status check, unless
+// the last status seen was final, so a status outage cannot hold it back.
+// Without a ctx deadline it gives up after DefaultTerminateTimeout.
+func (job JarviceJob) TerminateAndWait(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTerminateTimeout)
+		defer cancel()
+	}
+	ticker := time.NewTicker(terminatePollInterval)
+	defer ticker.Stop()
+	state := StateUnknown
+	if job.lastState != nil {
+		state = *job.lastState
+	}
+	terminates := 0
+	for attempt := 1; ; attempt++ {
+		if !state.Terminal() {
+			terminates++
+			logger.Ologger.Info("Terminating job "+job.Number, "attempt", attempt)
+			if _, err := job.client.PostForm(ctx, "/jarvice/terminate", job.values); err != nil {
+				logger.Ologger.Warn(err.Error(), "job", job.Number)
+			}
+		}
+		current, _, err := job.Status(ctx)
+		if errors.Is(err, ErrJobNotFound) {
+			logger.Ologger.Warn(err.Error())
+			return nil
+		} else if err != nil && !retryable(err, true) {
+			// a rejected apikey does not mean the job ended
+			return fmt.Errorf("JARVICE job %s not terminated: %w", job.Number, err)
+		} else if err != nil {
+			logger.Ologger.Warn(err.Error(), "job", job.Number)
+		} else if current.Terminal() {
+			if terminates > 0 {
+				logger.Ologger.Info("JARVICE job " + job.Number + " " + current.String())
+			}
+			return nil
+		} else {
+			state = current
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: job %s %s after %d attempts", ErrTerminateTimeout, job.Number, state, attempt)
+		case <-ticker.C:
+		}
+	}
 }
@@ -31,11 +31,15 @@ policies, either expressed or implied, of Nimbix, Inc.
 package jobs
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -47,10 +51,25 @@ const (
 	number   = "555"
 	fnumber  = "777"
 	nfnumber = "999"
+	cnumber  = "888"
+	enumber  = "666"
 )
 
 var (
 	job *JarviceJob = &JarviceJob{}
+
+	jobStates = map[string]string{
+		number:  "PROCESSING STARTING",
+		fnumber: "COMPLETED",
+		cnumber: "CANCELED",
+		enumber: "COMPLETED WITH ERROR",
+	}
+
+	// details reported for finished jobs
+	jobDetails = map[string]JobStatus{
+		fnumber: {SubmitTime: 1700000000, StartTime: 1700000060, EndTime: 1700003660, Walltime: "01:00:00", ExitCode: "0"},
+		enumber: {StartTime: 1700000060, Walltime: "00:02:30", ExitCode: "2"},
+	}
 )
 
 func checkApiArgs(values url.Values) bool {
@@ -121,24 +140,11 @@ func jarviceServer(t *testing.T, terminate bool) *httptest.Server {
 						w.WriteHeader(http.StatusBadRequest)
 						return
 					} else {
-						if query.Get("number") == number {
-							jobStatusList := JobStatusList{
-								number: JobStatus{
-									Status: "PROCESSING STARTING",
-								},
-							}
-							if resp, jerr := json.Marshal(jobStatusList); jerr != nil {
-								w.WriteHeader(http.StatusInternalServerError)
-								return
-							} else {
-								w.Write(resp)
-								return
-							}
-						} else if query.Get("number") == fnumber {
+						if status, ok := jobStates[query.Get("number")]; ok {
+							jobStatus := jobDetails[query.Get("number")]
+							jobStatus.Status = status
 							jobStatusList := JobStatusList{
-								fnumber: JobStatus{
-									Status: "COMPLETED",
-								},
+								query.Get("number"): jobStatus,
 							}
 							if resp, jerr := json.Marshal(jobStatusList); jerr != nil {
 								w.WriteHeader(http.StatusInternalServerError)
@@ -170,7 +176,7 @@ func jarviceServer(t *testing.T, terminate bool) *httptest.Server {
 func TestCheckAuth(t *testing.T) {
 	job = NewJarviceJob(apiHost, username, apikey, number)
 	ts := jarviceServer(t, false)
-	if !job.CheckAuth() {
+	if !job.CheckAuth(context.Background()) {
 		t.Error("CheckAuth() failed")
 	}
 	ts.Close()
@@ -179,7 +185,7 @@ func TestCheckAuth(t *testing.T) {
 func TestCheckAuthUnauthorized(t *testing.T) {
 	job = NewJarviceJob(apiHost, username, apikey+"123", nfnumber)
 	ts := jarviceServer(t, false)
-	if job.CheckAuth() {
+	if job.CheckAuth(context.Background()) {
 		t.Error("CheckAuthUnauthorized() failed")
 	}
 	ts.Close()
@@ -188,7 +194,7 @@ func TestCheckAuthUnauthorized(t *testing.T) {
 func TestRunning(t *testing.T) {
 	job := NewJarviceJob(apiHost, username, apikey, number)
 	ts := jarviceServer(t, false)
-	if !job.Running() {
+	if !job.Running(context.Background()) {
 		t.Error("Running() failed")
 	}
 	ts.Close()
@@ -197,7 +203,7 @@ func TestRunning(t *testing.T) {
 func TestRunningNotFound(t *testing.T) {
 	job := NewJarviceJob(apiHost, username, apikey, nfnumber)
 	ts := jarviceServer(t, false)
-	if job.Running() {
+	if job.Running(context.Background()) {
 		t.Error("RunningNotFound() failed")
 	}
 	ts.Close()
@@ -206,7 +212,7 @@ func TestRunningNotFound(t *testing.T) {
 func TestExitSuccess(t *testing.T) {
 	job := NewJarviceJob(apiHost, username, apikey, fnumber)
 	ts := jarviceServer(t, false)
-	if !job.ExitSuccess() {
+	if !job.ExitSuccess(context.Background()) {
 		t.Error("ExitSuccess() failed")
 	}
 	ts.Close()
@@ -215,23 +221,266 @@ func TestExitSuccess(t *testing.T) {
 func TestExitSuccessNotFound(t *testing.T) {
 	job := NewJarviceJob(apiHost, username, apikey, nfnumber)
 	ts := jarviceServer(t, false)
-	if job.ExitSuccess() {
+	if job.ExitSuccess(context.Background()) {
 		t.Error("ExitSuccessNotFound() failed")
 	}
 	ts.Close()
 }
 
+func TestStatus(t *testing.T) {
+	expected := map[string]JobState{
+		number:  StateProcessingStarting,
+		fnumber: StateCompleted,
+		cnumber: StateCanceled,
+		enumber: StateCompletedWithError,
+	}
+	for n, want := range expected {
+		job := NewJarviceJob(apiHost, username, apikey, n)
+		ts := jarviceServer(t, false)
+		state, raw, err := job.Status(context.Background())
+		ts.Close()
+		if err != nil {
+			t.Errorf("Status() failed for %s: %s", n, err.Error())
+			continue
+		}
+		if state != want {
+			t.Errorf("Status() for %s returned %s, expected %s", n, state, want)
+		}
+		jobStatus := JobStatus{}
+		if err := json.Unmarshal(raw, &jobStatus); err != nil || jobStatus.Status != want.String() {
+			t.Errorf("Status() raw payload for %s: %s", n, string(raw))
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	ts := jarviceServer(t, false)
+	info, err := NewJarviceJob(apiHost, username, apikey, fnumber).Info(context.Background())
+	ts.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State != StateCompleted || info.ExitCode == nil || *info.ExitCode != 0 {
+		t.Errorf("Info() returned %s exit code %v", info.State, info.ExitCode)
+	}
+	if info.StartTime.Unix() != 1700000060 || info.EndTime.Unix() != 1700003660 || info.Runtime != time.Hour {
+		t.Errorf("Info() returned start %s end %s runtime %s", info.StartTime, info.EndTime, info.Runtime)
+	}
+
+	ts = jarviceServer(t, false)
+	info, err = NewJarviceJob(apiHost, username, apikey, enumber).Info(context.Background())
+	ts.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ExitCode == nil || *info.ExitCode != 2 || !info.EndTime.IsZero() || info.Runtime != 150*time.Second {
+		t.Errorf("Info() returned exit code %v end %s runtime %s", info.ExitCode, info.EndTime, info.Runtime)
+	}
+
+	ts = jarviceServer(t, false)
+	info, err = NewJarviceJob(apiHost, username, apikey, cnumber).Info(context.Background())
+	ts.Close()
+	if err != nil || info.ExitCode != nil || info.Runtime != 0 {
+		t.Errorf("Info() without details returned %v %v", info, err)
+	}
+}
+
+func TestParseWalltime(t *testing.T) {
+	if d, err := parseWalltime("26:03:04"); err != nil || d != 26*time.Hour+3*time.Minute+4*time.Second {
+		t.Errorf("parseWalltime() returned %s %v", d, err)
+	}
+	for _, walltime := range []string{"", "1:2", "aa:00:00", "00:-1:00"} {
+		if _, err := parseWalltime(walltime); err == nil {
+			t.Errorf("parseWalltime(%q) did not fail", walltime)
+		}
+	}
+}
+
+func TestStatusNotFound(t *testing.T) {
+	job := NewJarviceJob(apiHost, username, apikey, nfnumber)
+	ts := jarviceServer(t, false)
+	if _, _, err := job.Status(context.Background()); !errors.Is(err, ErrJobNotFound) {
+		t.Error("StatusNotFound() failed")
+	}
+	ts.Close()
+}
+
+func TestRunningCanceled(t *testing.T) {
+	job := NewJarviceJob(apiHost, username, apikey, cnumber)
+	ts := jarviceServer(t, false)
+	if running, err := job.RunningWithError(context.Background()); running || err == nil {
+		t.Error("RunningCanceled() failed")
+	}
+	ts.Close()
+}
+
+func TestExitSuccessCompletedWithError(t *testing.T) {
+	job := NewJarviceJob(apiHost, username, apikey, enumber)
+	ts := jarviceServer(t, false)
+	if job.ExitSuccess(context.Background()) {
+		t.Error("ExitSuccessCompletedWithError() failed")
+	}
+	ts.Close()
+}
+
+func TestParseJobState(t *testing.T) {
+	for _, state := range []JobState{StateSubmitted, StateProcessingStarting,
+		StateCompleted, StateCompletedWithError, StateTerminated,
+		StateCanceled, StateExempt} {
+		if parsed, err := ParseJobState(state.String()); err != nil || parsed != state {
+			t.Errorf("ParseJobState(%q) failed", state.String())
+		}
+	}
+	if _, err := ParseJobState("PROCESSING SHUTDOWN"); !errors.Is(err, ErrUnknownJobState) {
+		t.Error("ParseJobState() accepted an unknown state")
+	}
+	if _, err := ParseJobState("UNKNOWN"); err == nil {
+		t.Error("ParseJobState() accepted UNKNOWN")
+	}
+}
+
+func TestValidateTransition(t *testing.T) {
+	valid := [][2]JobState{
+		{StateUnknown, StateSubmitted},
+		{StateSubmitted, StateSubmitted},
+		{StateSubmitted, StateProcessingStarting},
+		{StateSubmitted, StateCanceled},
+		{StateProcessingStarting, StateCompleted},
+		{StateProcessingStarting, StateCompletedWithError},
+		{StateProcessingStarting, StateTerminated},
+		{StateCompleted, StateCompleted},
+	}
+	for _, tr := range valid {
+		if err := ValidateTransition(tr[0], tr[1]); err != nil {
+			t.Error(err.Error())
+		}
+	}
+	invalid := [][2]JobState{
+		{StateProcessingStarting, StateSubmitted},
+		{StateCompleted, StateProcessingStarting},
+		{StateTerminated, StateCompleted},
+		{StateCanceled, StateSubmitted},
+	}
+	for _, tr := range invalid {
+		if err := ValidateTransition(tr[0], tr[1]); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("ValidateTransition(%s, %s) should fail", tr[0], tr[1])
+		}
+	}
+}
+
 func TestTerminate(t *testing.T) {
 	job := NewJarviceJob(apiHost, username, apikey, number)
 	ts := jarviceServer(t, true)
-	job.Terminate()
+	job.Terminate(context.Background())
 	ts.Close()
 }
 
-func TestGoogleShutdownScript(t *testing.T) {
-	job := NewJarviceJob(apiHost, username, apikey, number)
-	if job.GoogleShutdownScript() != "#!/bin/bash \ncurl \""+apiHost+"/jarvice/terminate?username="+
-		username+"&apikey="+apikey+"&number="+number+"\"" {
-		t.Error("GoogleShutdownScript() failed")
+// terminateServer reports the job processing until it received terminates
+// terminate requests, and TERMINATED afterwards
+func terminateServer(status string, terminates int32) (*httptest.Server, *JarviceJob, *int32) {
+	var calls int32
+	ts, client := clientServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/jarvice/terminate":
+			atomic.AddInt32(&calls, 1)
+		case "/jarvice/status":
+			current := status
+			if terminates > 0 && atomic.LoadInt32(&calls) >= terminates {
+				current = "TERMINATED"
+			}
+			json.NewEncoder(w).Encode(JobStatusList{number: JobStatus{Status: current}})
+		}
+	})
+	return ts, NewJarviceJobWithClient(client, username, apikey, number), &calls
+}
+
+func TestTerminateAndWait(t *testing.T) {
+	terminatePollInterval = time.Millisecond
+	ts, job, calls := terminateServer("PROCESSING STARTING", 3)
+	defer ts.Close()
+	if err := job.TerminateAndWait(context.Background()); err != nil {
+		t.Errorf("TerminateAndWait() failed: %s", err.Error())
+	}
+	if atomic.LoadInt32(calls) != 3 {
+		t.Errorf("expected 3 terminate requests, got %d", atomic.LoadInt32(calls))
+	}
+}
+
+func TestTerminateAndWaitUnauthorized(t *testing.T) {
+	terminatePollInterval = time.Millisecond
+	ts, client := clientServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	defer ts.Close()
+	job := NewJarviceJobWithClient(client, username, apikey, number)
+	if _, _, err := job.Status(context.Background()); errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Status() reported an unauthorized call as not found: %v", err)
+	}
+	err := job.TerminateAndWait(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || !strings.Contains(err.Error(), "job "+number) {
+		t.Errorf("expected unauthorized error for job %s, got %v", number, err)
+	}
+}
+
+func TestTerminateAndWaitCompleted(t *testing.T) {
+	terminatePollInterval = time.Millisecond
+	ts, job, calls := terminateServer("COMPLETED", 0)
+	defer ts.Close()
+	if _, _, err := job.Status(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.TerminateAndWait(context.Background()); err != nil {
+		t.Errorf("TerminateAndWait() failed: %s", err.Error())
+	}
+	if atomic.LoadInt32(calls) != 0 {
+		t.Error("TerminateAndWait() terminated a completed job")
+	}
+}
+
+func TestTerminateAndWaitStatusUnavailable(t *testing.T) {
+	terminatePollInterval = time.Millisecond
+	var calls int32
+	ts, client := clientServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jarvice/terminate" {
+			atomic.AddInt32(&calls, 1)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer ts.Close()
+	client.Retries = 0
+	job := NewJarviceJobWithClient(client, username, apikey, number)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := job.TerminateAndWait(ctx); !errors.Is(err, ErrTerminateTimeout) {
+		t.Errorf("expected terminate timeout, got %v", err)
+	}
+	if atomic.LoadInt32(&calls) < 2 {
+		t.Errorf("expected terminate requests while the status is unavailable, got %d", atomic.LoadInt32(&calls))
+	}
+}
+
+func TestTerminateAndWaitTimeout(t *testing.T) {
+	terminatePollInterval = time.Millisecond
+	ts, job, _ := terminateServer("PROCESSING STARTING", 0)
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := job.TerminateAndWait(ctx)
+	if !errors.Is(err, ErrTerminateTimeout) || !strings.Contains(err.Error(), "job "+number+" PROCESSING STARTING") {
+		t.Errorf("expected terminate timeout, got %v", err)
+	}
+}
+
+func TestGetJobOutputUnavailable(t *testing.T) {
+	ts, client := clientServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	defer ts.Close()
+	client.Retries = 0
+	job := NewJarviceJobWithClient(client, username, apikey, number)
+	if lines, err := job.GetJobOutput(context.Background()); err == nil || lines != 0 {
+		t.Errorf("GetJobOutput() returned %d, %v", lines, err)
 	}
 }
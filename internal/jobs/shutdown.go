@@ -0,0 +1,147 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package jobs
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"jarvice.io/dragen/internal/secrets"
+)
+
+var jobNumberPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// shutdownScript terminates the JARVICE job from a Compute Engine shutdown
+// script. With secret names the JARVICE credentials are read from Secret
+// Manager with the instance service account token, so only secret names end
+// up in instance metadata. Otherwise the credentials are part of the script.
+// The guest agent copies the script output to the serial console.
+var shutdownScript = template.Must(template.New("shutdown").Funcs(template.FuncMap{
+	"quote": shellQuote,
+}).Parse(`#!/bin/bash
+API_HOST='{{.ApiHost}}'
+JOB_NUMBER='{{.Number}}'
+{{- if .UsernameSecret}}
+USERNAME_SECRET='{{.UsernameSecret}}'
+APIKEY_SECRET='{{.ApikeySecret}}'
+METADATA='http://metadata.google.internal/computeMetadata/v1'
+{{- end}}
+CURL='curl -sS -f --retry 5 --retry-delay 2 --retry-connrefused --max-time 30'
+
+log() {
+	echo "jarvice-shutdown: $*"
+}
+
+dir=$(mktemp -d) || exit 1
+trap 'rm -rf "$dir"' EXIT
+chmod 700 "$dir"
+{{if .UsernameSecret}}
+token=$($CURL -H 'Metadata-Flavor: Google' "$METADATA/instance/service-accounts/default/token" |
+	sed -n 's/.*"access_token" *: *"\([^"]*\)".*/\1/p')
+if [ -z "$token" ]; then
+	log "unable to get service account token, JARVICE job $JOB_NUMBER not terminated"
+	exit 1
+fi
+
+secret() {
+	$CURL -H "Authorization: Bearer $token" "https://secretmanager.googleapis.com/v1/$1:access" |
+		sed -n 's/.*"data" *: *"\([^"]*\)".*/\1/p' | base64 -d
+}
+
+if ! secret "$USERNAME_SECRET" >"$dir/username" || ! secret "$APIKEY_SECRET" >"$dir/apikey" ||
+	[ ! -s "$dir/username" ] || [ ! -s "$dir/apikey" ]; then
+	log "unable to read JARVICE credentials from Secret Manager, JARVICE job $JOB_NUMBER not terminated"
+	exit 1
+fi
+{{- else}}
+printf '%s' {{quote .Username}} >"$dir/username"
+printf '%s' {{quote .Apikey}} >"$dir/apikey"
+{{- end}}
+
+if output=$($CURL -X POST --data-urlencode "username@$dir/username" --data-urlencode "apikey@$dir/apikey" \
+	--data-urlencode "number=$JOB_NUMBER" "$API_HOST/jarvice/terminate" 2>&1); then
+	log "JARVICE job $JOB_NUMBER terminated"
+else
+	log "unable to terminate JARVICE job $JOB_NUMBER: $output"
+	exit 1
+fi
+`))
+
+// GoogleShutdownScript returns a Compute Engine shutdown script that
+// terminates the job using the JARVICE credentials stored in the given
+// Secret Manager secret versions (projects/P/secrets/S/versions/V)
+func (job JarviceJob) GoogleShutdownScript(usernameSecret, apikeySecret string) (string, error) {
+	for _, secret := range []string{usernameSecret, apikeySecret} {
+		if err := secrets.ValidateVersionName(secret); err != nil {
+			return "", err
+		}
+	}
+	return job.renderShutdownScript(map[string]string{
+		"UsernameSecret": usernameSecret,
+		"ApikeySecret":   apikeySecret,
+	})
+}
+
+// GoogleCredentialsShutdownScript returns a Compute Engine shutdown script
+// that terminates the job with the JARVICE credentials of the job written
+// into the script, for instances whose metadata already holds them
+func (job JarviceJob) GoogleCredentialsShutdownScript() (string, error) {
+	return job.renderShutdownScript(map[string]string{
+		"Username": job.values.Get("username"),
+		"Apikey":   job.values.Get("apikey"),
+	})
+}
+
+func (job JarviceJob) renderShutdownScript(values map[string]string) (string, error) {
+	if !jobNumberPattern.MatchString(job.Number) {
+		return "", errors.New("invalid JARVICE job number: " + job.Number)
+	}
+	apiHost, err := url.Parse(job.client.ApiHost)
+	if err != nil || (apiHost.Scheme != "https" && apiHost.Scheme != "http") ||
+		strings.ContainsAny(job.client.ApiHost, "'\n") {
+		return "", errors.New("invalid JARVICE API URL: " + job.client.ApiHost)
+	}
+	values["ApiHost"] = job.client.ApiHost
+	values["Number"] = job.Number
+	var script strings.Builder
+	if err := shutdownScript.Execute(&script, values); err != nil {
+		return "", err
+	}
+	return script.String(), nil
+}
+
+// shellQuote quotes value as a single shell word
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
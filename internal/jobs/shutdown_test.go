@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package jobs
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	usernameSecret = "projects/p/secrets/username/versions/latest"
+	apikeySecret   = "projects/p/secrets/apikey/versions/3"
+)
+
+// fakeCurl answers the metadata token and Secret Manager requests of the
+// shutdown script and records the terminate request in $RECORD
+const fakeCurl = `#!/bin/sh
+for arg; do url=$arg; done
+case "$url" in
+*/token)
+	echo '{"access_token": "token", "expires_in": 3599, "token_type": "Bearer"}' ;;
+*/username/versions/latest:access)
+	echo '{"name": "username", "payload": {"data": "'$(printf jarvice | base64)'"}}' ;;
+*/apikey/versions/3:access)
+	echo '{"name": "apikey", "payload": {"data": "'$(printf abc123 | base64)'"}}' ;;
+*/jarvice/terminate)
+	echo "$url" >>"$RECORD"
+	for arg; do
+		case "$arg" in
+		-X|POST) echo "$arg" >>"$RECORD" ;;
+		*@*) echo "${arg%%@*}=$(cat "${arg#*@}")" >>"$RECORD" ;;
+		number=*) echo "$arg" >>"$RECORD" ;;
+		esac
+	done ;;
+*)
+	exit 22 ;;
+esac
+`
+
+func TestGoogleShutdownScript(t *testing.T) {
+	job := NewJarviceJob(apiHost, username, apikey, number)
+	script, err := job.GoogleShutdownScript(usernameSecret, apikeySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(script, apikey) || strings.Contains(script, "username="+username) {
+		t.Error("GoogleShutdownScript() leaked JARVICE credentials")
+	}
+	if !strings.Contains(script, usernameSecret) || !strings.Contains(script, apikeySecret) {
+		t.Error("GoogleShutdownScript() missing secret names")
+	}
+}
+
+func TestGoogleShutdownScriptInvalid(t *testing.T) {
+	job := NewJarviceJob(apiHost, username, apikey, number)
+	for _, secret := range []string{"", "jarviceApiKey", "projects/p/secrets/k'; reboot #/versions/1"} {
+		if _, err := job.GoogleShutdownScript(usernameSecret, secret); err == nil {
+			t.Errorf("GoogleShutdownScript() accepted secret %q", secret)
+		}
+	}
+	job = NewJarviceJob("https://api'$(id)", username, apikey, number)
+	if _, err := job.GoogleShutdownScript(usernameSecret, apikeySecret); err == nil {
+		t.Error("GoogleShutdownScript() accepted an invalid API URL")
+	}
+}
+
+func TestGoogleShutdownScriptRun(t *testing.T) {
+	for _, tool := range []string{"bash", "base64", "sed", "mktemp"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip(tool + " not available")
+		}
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "curl"), []byte(fakeCurl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	job := NewJarviceJob(apiHost, username, apikey, number)
+	script, err := job.GoogleShutdownScript(usernameSecret, apikeySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := filepath.Join(dir, "record")
+	cmd := exec.Command("bash", "-c", script)
+	cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"), "RECORD="+record)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("shutdown script failed: %v %s", err, output)
+	}
+	if !strings.Contains(string(output), "JARVICE job "+number+" terminated") {
+		t.Errorf("shutdown script logged %q", output)
+	}
+	request, _ := os.ReadFile(record)
+	expected := apiHost + "/jarvice/terminate\n-X\nPOST\nusername=" + username + "\napikey=" + apikey + "\nnumber=" + number + "\n"
+	if string(request) != expected {
+		t.Errorf("shutdown script sent %q", request)
+	}
+
+	script, _ = job.GoogleShutdownScript(usernameSecret, "projects/p/secrets/missing/versions/1")
+	cmd = exec.Command("bash", "-c", script)
+	cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"), "RECORD="+record)
+	output, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "unable to read JARVICE credentials") {
+		t.Errorf("shutdown script without credentials: %v %s", err, output)
+	}
+}
+
+func TestGoogleCredentialsShutdownScriptRun(t *testing.T) {
+	for _, tool := range []string{"bash", "mktemp"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip(tool + " not available")
+		}
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "curl"), []byte(fakeCurl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	quoted := `it's $(id) "key"`
+	job := NewJarviceJob(apiHost, username, quoted, number)
+	script, err := job.GoogleCredentialsShutdownScript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(script, "secretmanager") {
+		t.Error("GoogleCredentialsShutdownScript() reads Secret Manager")
+	}
+	record := filepath.Join(dir, "record")
+	cmd := exec.Command("bash", "-c", script)
+	cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"), "RECORD="+record)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("shutdown script failed: %v %s", err, output)
+	}
+	request, _ := os.ReadFile(record)
+	expected := apiHost + "/jarvice/terminate\n-X\nPOST\nusername=" + username + "\napikey=" + quoted + "\nnumber=" + number + "\n"
+	if string(request) != expected {
+		t.Errorf("shutdown script sent %q", request)
+	}
+}
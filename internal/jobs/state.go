@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package jobs
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrJobNotFound       = errors.New("JARVICE job not found")
+	ErrUnknownJobState   = errors.New("unknown JARVICE job state")
+	ErrInvalidTransition = errors.New("invalid JARVICE job state transition")
+	ErrTerminateTimeout  = errors.New("JARVICE job still running after terminate")
+)
+
+// JobState is the job_status reported by the JARVICE API
+type JobState int
+
+const (
+	StateUnknown JobState = iota
+	StateSubmitted
+	StateProcessingStarting
+	StateCompleted
+	StateCompletedWithError
+	StateTerminated
+	StateCanceled
+	StateExempt
+)
+
+var jobStateNames = map[JobState]string{
+	StateUnknown:            "UNKNOWN",
+	StateSubmitted:          "SUBMITTED",
+	StateProcessingStarting: "PROCESSING STARTING",
+	StateCompleted:          "COMPLETED",
+	StateCompletedWithError: "COMPLETED WITH ERROR",
+	StateTerminated:         "TERMINATED",
+	StateCanceled:           "CANCELED",
+	StateExempt:             "EXEMPT",
+}
+
+func ParseJobState(status string) (JobState, error) {
+	for state, name := range jobStateNames {
+		if state != StateUnknown && name == status {
+			return state, nil
+		}
+	}
+	return StateUnknown, fmt.Errorf("%w %q", ErrUnknownJobState, status)
+}
+
+func (s JobState) String() string {
+	if name, ok := jobStateNames[s]; ok {
+		return name
+	}
+	return jobStateNames[StateUnknown]
+}
+
+// Terminal reports whether JARVICE will never move the job out of this state
+func (s JobState) Terminal() bool {
+	switch s {
+	case StateCompleted, StateCompletedWithError, StateTerminated,
+		StateCanceled, StateExempt:
+		return true
+	}
+	return false
+}
+
+func (s JobState) Success() bool {
+	return s == StateCompleted
+}
+
+// ValidateTransition checks that a job observed in state from can next be
+// observed in state to. Polling may skip states, so only moves backwards or
+// out of a terminal state are rejected.
+func ValidateTransition(from, to JobState) error {
+	if from == to || from == StateUnknown {
+		return nil
+	}
+	valid := false
+	switch from {
+	case StateSubmitted:
+		valid = to != StateUnknown
+	case StateProcessingStarting:
+		valid = to.Terminal()
+	}
+	if !valid {
+		return fmt.Errorf("%w %s -> %s", ErrInvalidTransition, from, to)
+	}
+	return nil
+}
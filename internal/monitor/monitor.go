@@ -31,56 +31,290 @@ policies, either expressed or implied, of Nimbix, Inc.
 package monitor
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
 	"syscall"
 	"time"
+
+	"jarvice.io/dragen/internal/logger"
+)
+
+const (
+	DefaultPollInterval     = 5 * time.Second
+	DefaultLogInterval      = 5 * time.Second
+	DefaultMaxPollErrors    = 30
+	DefaultMaxPollErrorTime = 15 * time.Minute
 )
 
+// Meter reports on a job. Running returns a terminal State only once the
+// job reached a definitive end; an error means the state could not be
+// determined and polling continues within the error budget.
 type Meter interface {
-	Init() bool
-	Running() bool
-	ExitSuccess() bool
-	Cleanup()
-	Output()
+	Init(ctx context.Context) (State, error)
+	Running(ctx context.Context) (State, error)
+	ExitSuccess(ctx context.Context) (State, error)
+	Cleanup(ctx context.Context) error
+	// Output streams new job output and returns the number of new lines,
+	// or an error when the output could not be retrieved
+	Output(ctx context.Context) (int, error)
+}
+
+// Config controls polling. Job status is polled every PollInterval and job
+// output is streamed every LogInterval from a separate goroutine. A zero
+// MaxPollErrors or MaxPollErrorTime disables that limit of the error budget.
+// MaxRuntime bounds the time spent polling a started job; once it passes
+// the job is stopped through Meter.Cleanup. StallTimeout warns when no new
+// output appeared within that window, and StallTerminate then stops the job
+// as well.
+type Config struct {
+	PollInterval     time.Duration
+	LogInterval      time.Duration
+	MaxPollErrors    int
+	MaxPollErrorTime time.Duration
+	MaxRuntime       time.Duration
+	StallTimeout     time.Duration
+	StallTerminate   bool
+}
+
+func DefaultConfig() Config {
+	config := Config{
+		PollInterval:     DefaultPollInterval,
+		LogInterval:      DefaultLogInterval,
+		MaxPollErrors:    DefaultMaxPollErrors,
+		MaxPollErrorTime: DefaultMaxPollErrorTime,
+	}
+	if interval, err := strconv.ParseInt(os.Getenv("JARVICE_POLL_INTERVAL"), 10, 64); err == nil {
+		config.PollInterval = time.Duration(interval) * time.Second
+	}
+	if interval, err := strconv.ParseInt(os.Getenv("JARVICE_LOG_INTERVAL"), 10, 64); err == nil {
+		config.LogInterval = time.Duration(interval) * time.Second
+	}
+	return config
+}
+
+// ErrorBudget counts consecutive poll failures. A zero MaxErrors or MaxTime
+// disables that limit.
+type ErrorBudget struct {
+	MaxErrors int
+	MaxTime   time.Duration
+	count     int
+	first     time.Time
+}
+
+// Fail records a failure at now and reports whether the budget still allows
+// polling to continue
+func (b *ErrorBudget) Fail(now time.Time) bool {
+	if b.count == 0 {
+		b.first = now
+	}
+	b.count += 1
+	if b.MaxErrors > 0 && b.count > b.MaxErrors {
+		return false
+	}
+	if b.MaxTime > 0 && now.Sub(b.first) > b.MaxTime {
+		return false
+	}
+	return true
+}
+
+// Reset starts a new streak after a successful poll
+func (b *ErrorBudget) Reset() {
+	b.count = 0
+}
+
+// Count returns the failures of the current streak
+func (b *ErrorBudget) Count() int {
+	return b.count
+}
+
+// stallWatch records when job output last made progress. The watch is
+// paused while the output cannot be retrieved since silence is unknown then.
+type stallWatch struct {
+	timeout  time.Duration
+	progress time.Time
+	warned   bool
+	failed   time.Time
+}
+
+// unavailable pauses the watch from the first failure on
+func (w *stallWatch) unavailable(now time.Time) {
+	if w.failed.IsZero() {
+		w.failed = now
+	}
+}
+
+func (w *stallWatch) output(now time.Time, lines int) {
+	if !w.failed.IsZero() {
+		w.progress = w.progress.Add(now.Sub(w.failed))
+		w.failed = time.Time{}
+	}
+	if lines > 0 {
+		w.progress = now
+		w.warned = false
+	}
 }
 
-func StartMonitor(meter Meter) error {
-	catchSignal := make(chan os.Signal, 1)
-	signal.Notify(catchSignal, syscall.SIGINT, syscall.SIGTERM)
+// stalled reports whether the job has been silent longer than the timeout,
+// and whether this is the first check to notice it
+func (w *stallWatch) stalled(now time.Time) (bool, bool) {
+	if w.timeout <= 0 || !w.failed.IsZero() || now.Sub(w.progress) <= w.timeout {
+		return false, false
+	}
+	first := !w.warned
+	w.warned = true
+	return true, first
+}
+
+// StartMonitor runs meter until the job ends or SIGINT/SIGTERM is received.
+// The returned error joins an *InitError or *RuntimeError with the
+// *CleanupError, if any.
+func StartMonitor(meter Meter, config Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	// cleanup must still run once a signal cancelled ctx
+	cleanupCtx := context.WithoutCancel(ctx)
+
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+	if config.LogInterval <= 0 {
+		config.LogInterval = DefaultLogInterval
+	}
+
+	if state, err := meter.Init(ctx); err != nil {
+		if ctx.Err() != nil {
+			err = errors.Join(ErrInterrupted, err)
+		}
+		return errors.Join(&InitError{State: state, Err: err}, cleanup(cleanupCtx, meter))
+	}
 
-	interval, err := strconv.ParseInt(os.Getenv("JARVICE_POLL_INTERVAL"), 10, 64)
-	if err != nil {
-		interval = 5
+	runErr := poll(ctx, meter, config)
+	if runErr == nil {
+		if state, err := meter.ExitSuccess(cleanupCtx); err != nil {
+			runErr = &RuntimeError{State: state, Err: err}
+		} else if state != StateSucceeded {
+			runErr = &RuntimeError{State: state, Err: ErrJobFailed}
+		}
 	}
+	return errors.Join(runErr, cleanup(cleanupCtx, meter))
+}
+
+// poll checks the job status until it ends while stream follows its output.
+// A slow or failing Output never delays the status checks; stream is stopped
+// and waited for before poll returns.
+func poll(ctx context.Context, meter Meter, config Config) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	stalled := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		stream(streamCtx, meter, config, stalled)
+	}()
 
-	if !meter.Init() {
-		meter.Cleanup()
-		return errors.New("monitor init failed")
+	budget := ErrorBudget{
+		MaxErrors: config.MaxPollErrors,
+		MaxTime:   config.MaxPollErrorTime,
 	}
+	var runtimeLimit <-chan time.Time
+	if config.MaxRuntime > 0 {
+		limit := time.NewTimer(config.MaxRuntime)
+		defer limit.Stop()
+		runtimeLimit = limit.C
+	}
+	timer := time.NewTicker(config.PollInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return &RuntimeError{State: StateCanceled, Err: ErrInterrupted}
+		case <-runtimeLimit:
+			logger.Elogger.Error("maximum runtime exceeded, stopping job", "max-runtime", config.MaxRuntime.String())
+			return &RuntimeError{
+				State: StateRunning,
+				Err:   fmt.Errorf("%w after %s", ErrTimeout, config.MaxRuntime),
+			}
+		case err := <-stalled:
+			return err
+		case <-timer.C:
+			state, err := meter.Running(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					continue
+				}
+				if !budget.Fail(time.Now()) {
+					return &RuntimeError{
+						State: state,
+						Err:   fmt.Errorf("job status unavailable after %d attempts: %w", budget.count, err),
+					}
+				}
+				logger.Ologger.Warn("job status poll failed", "attempt", budget.count, "error", err.Error())
+				continue
+			}
+			budget.Reset()
+			if state.Terminal() {
+				return nil
+			}
+		}
+	}
+}
 
-	timer := time.NewTicker(time.Duration(interval) * time.Second)
+// stream calls Output every LogInterval until ctx is done. With
+// StallTerminate set, a stall is reported on stalled and streaming stops.
+// Failed Output calls do not count as silence.
+func stream(ctx context.Context, meter Meter, config Config, stalled chan<- error) {
+	stall := stallWatch{
+		timeout:  config.StallTimeout,
+		progress: time.Now(),
+	}
+	failures := 0
+	timer := time.NewTicker(config.LogInterval)
 	defer timer.Stop()
-Poll:
 	for {
 		select {
-		case <-catchSignal:
-			break Poll
+		case <-ctx.Done():
+			return
 		case <-timer.C:
-			if !meter.Running() {
-				break Poll
+			lines, err := meter.Output(ctx)
+			now := time.Now()
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				failures += 1
+				logger.Elogger.Error("job output unavailable", "attempt", failures, "error", err.Error())
+				stall.unavailable(now)
+				continue
+			}
+			failures = 0
+			stall.output(now, lines)
+			if isStalled, first := stall.stalled(now); isStalled {
+				silence := now.Sub(stall.progress).Round(time.Second)
+				if config.StallTerminate {
+					logger.Elogger.Error("job output stalled, stopping job", "silence", silence.String())
+					stalled <- &RuntimeError{
+						State: StateRunning,
+						Err:   fmt.Errorf("%w: no new output for %s", ErrStalled, silence),
+					}
+					return
+				} else if first {
+					logger.Ologger.Warn("job output stalled", "silence", silence.String())
+				}
 			}
-			meter.Output()
 		}
 	}
+}
 
-	success := meter.ExitSuccess()
-	meter.Cleanup()
-	if success {
-		return nil
-	} else {
-		return errors.New("job processing failed")
+func cleanup(ctx context.Context, meter Meter) error {
+	if err := meter.Cleanup(ctx); err != nil {
+		return &CleanupError{Err: err}
 	}
+	return nil
 }
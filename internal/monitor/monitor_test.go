@@ -0,0 +1,360 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"jarvice.io/dragen/internal/jobs"
+)
+
+type pollResult struct {
+	state State
+	err   error
+}
+
+type fakeMeter struct {
+	lines      int
+	outputErr  error
+	initErr    error
+	polls      []pollResult
+	exitState  State
+	cleanupErr error
+	calls      int
+	outputs    atomic.Int32
+	cleanups   int
+	// blockOutput makes Output hang until its context is done
+	blockOutput bool
+}
+
+func (m *fakeMeter) Init(ctx context.Context) (State, error) {
+	if m.initErr != nil {
+		return StateFailed, m.initErr
+	}
+	return StateRunning, nil
+}
+
+func (m *fakeMeter) Running(ctx context.Context) (State, error) {
+	if m.calls >= len(m.polls) {
+		return m.exitState, nil
+	}
+	result := m.polls[m.calls]
+	m.calls += 1
+	return result.state, result.err
+}
+
+func (m *fakeMeter) ExitSuccess(ctx context.Context) (State, error) {
+	return m.exitState, nil
+}
+
+func (m *fakeMeter) Cleanup(ctx context.Context) error {
+	m.cleanups += 1
+	return m.cleanupErr
+}
+
+func (m *fakeMeter) Output(ctx context.Context) (int, error) {
+	m.outputs.Add(1)
+	if m.blockOutput {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	return m.lines, m.outputErr
+}
+
+var errPoll = errors.New("status unavailable")
+
+func testConfig(maxErrors int) Config {
+	return Config{
+		PollInterval:  time.Millisecond,
+		LogInterval:   time.Millisecond,
+		MaxPollErrors: maxErrors,
+	}
+}
+
+func TestStartMonitorTransientErrors(t *testing.T) {
+	meter := &fakeMeter{
+		polls: []pollResult{
+			{StateRunning, nil},
+			{StateUnknown, errPoll},
+			{StateUnknown, errPoll},
+			{StateRunning, nil},
+			{StateUnknown, errPoll},
+			{StateUnknown, errPoll},
+			{StateRunning, nil},
+			{StateSucceeded, nil},
+		},
+		exitState: StateSucceeded,
+	}
+	if err := StartMonitor(meter, testConfig(2)); err != nil {
+		t.Errorf("StartMonitor() failed: %s", err.Error())
+	}
+	if meter.calls != len(meter.polls) {
+		t.Errorf("monitor stopped after %d polls", meter.calls)
+	}
+	if meter.cleanups != 1 {
+		t.Error("Cleanup() not called once")
+	}
+}
+
+func TestStartMonitorErrorBudgetExhausted(t *testing.T) {
+	meter := &fakeMeter{
+		polls: []pollResult{
+			{StateRunning, nil},
+			{StateUnknown, errPoll},
+			{StateUnknown, errPoll},
+			{StateUnknown, errPoll},
+			{StateRunning, nil},
+		},
+		exitState: StateSucceeded,
+	}
+	err := StartMonitor(meter, testConfig(2))
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || !errors.Is(err, errPoll) {
+		t.Errorf("expected RuntimeError wrapping poll error, got %v", err)
+	}
+	if meter.calls != 4 {
+		t.Errorf("expected 4 polls, got %d", meter.calls)
+	}
+	if meter.cleanups != 1 {
+		t.Error("Cleanup() not called once")
+	}
+}
+
+func TestStartMonitorInitFailed(t *testing.T) {
+	errInit := errors.New("no reservation")
+	errCleanup := errors.New("template still in use")
+	meter := &fakeMeter{initErr: errInit, cleanupErr: errCleanup}
+	err := StartMonitor(meter, testConfig(0))
+	var initErr *InitError
+	var cleanupErr *CleanupError
+	if !errors.As(err, &initErr) || !errors.Is(err, errInit) {
+		t.Errorf("expected InitError, got %v", err)
+	}
+	if !errors.As(err, &cleanupErr) || !errors.Is(err, errCleanup) {
+		t.Errorf("expected CleanupError, got %v", err)
+	}
+	if meter.calls != 0 || meter.cleanups != 1 {
+		t.Error("init failure handling failed")
+	}
+}
+
+func TestStartMonitorJobFailed(t *testing.T) {
+	meter := &fakeMeter{
+		polls:     []pollResult{{StateRunning, nil}, {StateFailed, nil}},
+		exitState: StateFailed,
+	}
+	err := StartMonitor(meter, testConfig(0))
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.State != StateFailed || !errors.Is(err, ErrJobFailed) {
+		t.Errorf("expected failed RuntimeError, got %v", err)
+	}
+}
+
+func TestStartMonitorJobCanceled(t *testing.T) {
+	meter := &fakeMeter{
+		polls:     []pollResult{{StateCanceled, nil}},
+		exitState: StateCanceled,
+	}
+	err := StartMonitor(meter, testConfig(0))
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.State != StateCanceled {
+		t.Errorf("expected canceled RuntimeError, got %v", err)
+	}
+}
+
+func TestStartMonitorMaxRuntime(t *testing.T) {
+	meter := &fakeMeter{exitState: StateRunning}
+	config := testConfig(0)
+	config.MaxRuntime = 20 * time.Millisecond
+	err := StartMonitor(meter, config)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if meter.outputs.Load() == 0 || meter.cleanups != 1 {
+		t.Error("max runtime handling failed")
+	}
+}
+
+func TestStartMonitorStallTerminate(t *testing.T) {
+	meter := &fakeMeter{exitState: StateRunning}
+	config := testConfig(0)
+	config.StallTimeout = 20 * time.Millisecond
+	config.StallTerminate = true
+	err := StartMonitor(meter, config)
+	if !errors.Is(err, ErrStalled) {
+		t.Errorf("expected stall, got %v", err)
+	}
+	if meter.cleanups != 1 {
+		t.Error("Cleanup() not called once")
+	}
+}
+
+func TestStartMonitorStallWarning(t *testing.T) {
+	polls := []pollResult{}
+	for i := 0; i < 50; i++ {
+		polls = append(polls, pollResult{StateRunning, nil})
+	}
+	meter := &fakeMeter{polls: polls, exitState: StateSucceeded}
+	config := testConfig(0)
+	config.StallTimeout = time.Millisecond
+	if err := StartMonitor(meter, config); err != nil {
+		t.Errorf("stall warning stopped the job: %v", err)
+	}
+}
+
+func TestStartMonitorOutputUnavailable(t *testing.T) {
+	polls := []pollResult{}
+	for i := 0; i < 100; i++ {
+		polls = append(polls, pollResult{StateRunning, nil})
+	}
+	meter := &fakeMeter{polls: polls, exitState: StateSucceeded, outputErr: errors.New("tail unavailable")}
+	config := testConfig(0)
+	config.StallTimeout = 5 * time.Millisecond
+	config.StallTerminate = true
+	if err := StartMonitor(meter, config); err != nil {
+		t.Errorf("failing output stopped the job: %v", err)
+	}
+}
+
+func TestStartMonitorBlockedOutput(t *testing.T) {
+	polls := []pollResult{}
+	for i := 0; i < 20; i++ {
+		polls = append(polls, pollResult{StateRunning, nil})
+	}
+	meter := &fakeMeter{polls: polls, exitState: StateSucceeded, blockOutput: true}
+	done := make(chan error)
+	go func() {
+		done <- StartMonitor(meter, testConfig(0))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("StartMonitor() failed: %s", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked Output stalled status polling")
+	}
+	if meter.outputs.Load() == 0 {
+		t.Error("Output() never called")
+	}
+	if meter.cleanups != 1 {
+		t.Error("Cleanup() not called once")
+	}
+}
+
+func TestStallWatch(t *testing.T) {
+	now := time.Now()
+	watch := stallWatch{timeout: time.Minute, progress: now}
+	if stalled, _ := watch.stalled(now.Add(time.Minute)); stalled {
+		t.Error("stallWatch stalled early")
+	}
+	if stalled, first := watch.stalled(now.Add(2 * time.Minute)); !stalled || !first {
+		t.Error("stallWatch missed stall")
+	}
+	if stalled, first := watch.stalled(now.Add(3 * time.Minute)); !stalled || first {
+		t.Error("stallWatch warned twice")
+	}
+	watch.output(now.Add(3*time.Minute), 0)
+	if stalled, _ := watch.stalled(now.Add(3 * time.Minute)); !stalled {
+		t.Error("stallWatch progressed without output")
+	}
+	watch.output(now.Add(3*time.Minute), 2)
+	if stalled, _ := watch.stalled(now.Add(4 * time.Minute)); stalled {
+		t.Error("stallWatch ignored output")
+	}
+	if stalled, first := watch.stalled(now.Add(5 * time.Minute)); !stalled || !first {
+		t.Error("stallWatch did not warn after new stall")
+	}
+	// silence before and after an outage adds up, the outage does not count
+	watch = stallWatch{timeout: time.Minute, progress: now}
+	watch.unavailable(now.Add(30 * time.Second))
+	if stalled, _ := watch.stalled(now.Add(time.Hour)); stalled {
+		t.Error("stallWatch stalled while output was unavailable")
+	}
+	watch.output(now.Add(time.Hour), 0)
+	if stalled, _ := watch.stalled(now.Add(time.Hour + 30*time.Second)); stalled {
+		t.Error("stallWatch counted the outage")
+	}
+	if stalled, _ := watch.stalled(now.Add(time.Hour + 31*time.Second)); !stalled {
+		t.Error("stallWatch missed the stall around the outage")
+	}
+	disabled := stallWatch{progress: now}
+	if stalled, _ := disabled.stalled(now.Add(time.Hour)); stalled {
+		t.Error("disabled stallWatch stalled")
+	}
+}
+
+func TestFromJobState(t *testing.T) {
+	expected := map[jobs.JobState]State{
+		jobs.StateSubmitted:          StatePending,
+		jobs.StateProcessingStarting: StateRunning,
+		jobs.StateCompleted:          StateSucceeded,
+		jobs.StateCompletedWithError: StateFailed,
+		jobs.StateExempt:             StateFailed,
+		jobs.StateTerminated:         StateCanceled,
+		jobs.StateCanceled:           StateCanceled,
+		jobs.StateUnknown:            StateUnknown,
+	}
+	for from, want := range expected {
+		if got := FromJobState(from); got != want {
+			t.Errorf("FromJobState(%s) = %s, expected %s", from, got, want)
+		}
+		if FromJobState(from).Terminal() != from.Terminal() {
+			t.Errorf("FromJobState(%s) changed terminal state", from)
+		}
+	}
+}
+
+func TestErrorBudget(t *testing.T) {
+	now := time.Now()
+	budget := ErrorBudget{MaxTime: time.Minute}
+	if !budget.Fail(now) || !budget.Fail(now.Add(time.Minute)) {
+		t.Error("ErrorBudget exhausted early")
+	}
+	if budget.Fail(now.Add(time.Minute + time.Second)) {
+		t.Error("ErrorBudget duration not enforced")
+	}
+	budget.Reset()
+	if !budget.Fail(now.Add(2 * time.Minute)) {
+		t.Error("ErrorBudget reset failed")
+	}
+	unlimited := ErrorBudget{}
+	for i := 0; i < 1000; i++ {
+		if !unlimited.Fail(now) {
+			t.Error("unlimited ErrorBudget exhausted")
+			break
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package monitor
+
+import (
+	"errors"
+	"strconv"
+
+	"jarvice.io/dragen/internal/jobs"
+)
+
+var (
+	ErrJobFailed   = errors.New("job processing failed")
+	ErrInterrupted = errors.New("interrupted by signal")
+	ErrTimeout     = errors.New("timeout: maximum runtime exceeded")
+	ErrStalled     = errors.New("job output stalled")
+)
+
+// State is the monitor's view of a job, independent of the job backend
+type State int
+
+const (
+	StateUnknown State = iota
+	StatePending
+	StateRunning
+	StateSucceeded
+	StateFailed
+	StateCanceled
+)
+
+var stateNames = map[State]string{
+	StateUnknown:   "unknown",
+	StatePending:   "pending",
+	StateRunning:   "running",
+	StateSucceeded: "succeeded",
+	StateFailed:    "failed",
+	StateCanceled:  "canceled",
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return stateNames[StateUnknown]
+}
+
+func (s State) Terminal() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCanceled
+}
+
+func FromJobState(state jobs.JobState) State {
+	switch state {
+	case jobs.StateSubmitted:
+		return StatePending
+	case jobs.StateProcessingStarting:
+		return StateRunning
+	case jobs.StateCompleted:
+		return StateSucceeded
+	case jobs.StateCompletedWithError, jobs.StateExempt:
+		return StateFailed
+	case jobs.StateTerminated, jobs.StateCanceled:
+		return StateCanceled
+	}
+	return StateUnknown
+}
+
+// InitError is returned when Meter.Init fails
+type InitError struct {
+	State State
+	Err   error
+}
+
+func (e *InitError) Error() string {
+	return "monitor init failed: " + e.Err.Error()
+}
+
+func (e *InitError) Unwrap() error {
+	return e.Err
+}
+
+// RuntimeError is returned when the job fails or its state is lost
+type RuntimeError struct {
+	State State
+	Err   error
+}
+
+func (e *RuntimeError) Error() string {
+	return "job " + e.State.String() + ": " + e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// CleanupError is returned when Meter.Cleanup fails
+type CleanupError struct {
+	Err error
+}
+
+func (e *CleanupError) Error() string {
+	return "cleanup failed: " + e.Err.Error()
+}
+
+func (e *CleanupError) Unwrap() error {
+	return e.Err
+}
+
+// ApplicationError is returned when the job ran and the application exited
+// with a non-zero code
+type ApplicationError struct {
+	ExitCode int
+}
+
+func (e *ApplicationError) Error() string {
+	return "application exited with code " + strconv.Itoa(e.ExitCode)
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/secretmanager/v1"
+)
+
+// Scheme prefixes a flag value that references a Secret Manager secret
+// version instead of holding the secret itself
+const Scheme = "sm://"
+
+var versionNamePattern = regexp.MustCompile(`^projects/[A-Za-z0-9_.-]+/secrets/[A-Za-z0-9_-]+/versions/[A-Za-z0-9_-]+$`)
+
+// Resolver returns the value referenced by a secret name
+type Resolver interface {
+	Resolve(ctx context.Context, name string) (string, error)
+}
+
+// ValidateVersionName checks a Secret Manager secret version name
+// (projects/P/secrets/S/versions/V)
+func ValidateVersionName(name string) error {
+	if !versionNamePattern.MatchString(name) {
+		return errors.New("invalid Secret Manager secret version: " + name)
+	}
+	return nil
+}
+
+// Reference returns the secret version name referenced by value
+func Reference(value string) (string, bool) {
+	if !strings.HasPrefix(value, Scheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(value, Scheme), true
+}
+
+// HasReference reports whether any of values is a secret reference
+func HasReference(values ...string) bool {
+	for _, value := range values {
+		if _, ok := Reference(value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveReferences replaces every sm:// reference in values with the
+// secret it names
+func ResolveReferences(ctx context.Context, resolver Resolver, values ...*string) error {
+	for _, value := range values {
+		if name, ok := Reference(*value); ok {
+			secret, err := resolver.Resolve(ctx, name)
+			if err != nil {
+				return err
+			}
+			*value = secret
+		}
+	}
+	return nil
+}
+
+// SecretManager resolves Secret Manager secret version names using the
+// application default credentials
+type SecretManager struct {
+	service *secretmanager.Service
+}
+
+func NewSecretManager(ctx context.Context, opts ...option.ClientOption) (*SecretManager, error) {
+	service, err := secretmanager.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretManager{service: service}, nil
+}
+
+func (s *SecretManager) Resolve(ctx context.Context, name string) (string, error) {
+	if err := ValidateVersionName(name); err != nil {
+		return "", err
+	}
+	resp, err := s.service.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return "", errors.New("unable to access secret " + name + ": " + err.Error())
+	}
+	if resp.Payload == nil {
+		return "", errors.New("secret " + name + " has no payload")
+	}
+	value, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", errors.New("secret " + name + " payload: " + err.Error())
+	}
+	return string(value), nil
+}
+
+// Env resolves names of environment variables
+type Env struct{}
+
+func (Env) Resolve(ctx context.Context, name string) (string, error) {
+	if value, ok := os.LookupEnv(name); ok && len(value) > 0 {
+		return value, nil
+	}
+	return "", errors.New("environment variable " + name + " not set")
+}
+
+// Fake resolves names from a map, standing in for Secret Manager in tests
+type Fake map[string]string
+
+func (f Fake) Resolve(ctx context.Context, name string) (string, error) {
+	if value, ok := f[name]; ok {
+		return value, nil
+	}
+	return "", errors.New("secret " + name + " not found")
+}
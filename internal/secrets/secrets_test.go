@@ -0,0 +1,124 @@
+/*
+Copyright (c) 2023, Nimbix, Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+
+The views and conclusions contained in the software and documentation are
+those of the authors and should not be interpreted as representing official
+policies, either expressed or implied, of Nimbix, Inc.
+*/
+
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/secretmanager/v1"
+)
+
+const apikeySecret = "projects/p/secrets/jarviceApiKey/versions/latest"
+
+func TestValidateVersionName(t *testing.T) {
+	valid := []string{apikeySecret, "projects/my-project/secrets/key_1/versions/3"}
+	for _, name := range valid {
+		if err := ValidateVersionName(name); err != nil {
+			t.Error(err.Error())
+		}
+	}
+	invalid := []string{"", "jarviceApiKey", "projects/p/secrets/k", "projects/p/secrets/k'; reboot #/versions/1"}
+	for _, name := range invalid {
+		if err := ValidateVersionName(name); err == nil {
+			t.Errorf("ValidateVersionName(%q) did not fail", name)
+		}
+	}
+}
+
+func TestSecretManager(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/"+apikeySecret+":access" {
+			http.Error(w, `{"error": {"code": 404, "message": "not found"}}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(secretmanager.AccessSecretVersionResponse{
+			Name: apikeySecret,
+			Payload: &secretmanager.SecretPayload{
+				Data: base64.StdEncoding.EncodeToString([]byte("abc123")),
+			},
+		})
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+	resolver, err := NewSecretManager(ctx, option.WithEndpoint(ts.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := resolver.Resolve(ctx, apikeySecret); err != nil || value != "abc123" {
+		t.Errorf("Resolve() returned %q %v", value, err)
+	}
+	if _, err := resolver.Resolve(ctx, "projects/p/secrets/missing/versions/1"); err == nil {
+		t.Error("Resolve() of a missing secret did not fail")
+	}
+	if _, err := resolver.Resolve(ctx, "jarviceApiKey"); err == nil {
+		t.Error("Resolve() accepted an invalid name")
+	}
+}
+
+func TestEnv(t *testing.T) {
+	t.Setenv("JARVICE_TEST_SECRET", "abc123")
+	if value, err := (Env{}).Resolve(context.Background(), "JARVICE_TEST_SECRET"); err != nil || value != "abc123" {
+		t.Errorf("Resolve() returned %q %v", value, err)
+	}
+	if _, err := (Env{}).Resolve(context.Background(), "JARVICE_TEST_MISSING"); err == nil {
+		t.Error("Resolve() of a missing variable did not fail")
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	resolver := Fake{
+		apikeySecret: "abc123",
+		"projects/p/secrets/batchS3AccessKey/versions/2": "GOOG1EXAMPLE",
+	}
+	apikey := Scheme + apikeySecret
+	accessKey := Scheme + "projects/p/secrets/batchS3AccessKey/versions/2"
+	username := "jarvice"
+	empty := ""
+	if !HasReference(username, apikey) || HasReference(username, empty) {
+		t.Error("HasReference() failed")
+	}
+	if err := ResolveReferences(context.Background(), resolver, &apikey, &username, &accessKey, &empty); err != nil {
+		t.Fatal(err)
+	}
+	if apikey != "abc123" || username != "jarvice" || accessKey != "GOOG1EXAMPLE" || empty != "" {
+		t.Errorf("ResolveReferences() returned %q %q %q %q", apikey, username, accessKey, empty)
+	}
+	missing := Scheme + "projects/p/secrets/missing/versions/1"
+	if err := ResolveReferences(context.Background(), resolver, &missing); err == nil {
+		t.Error("ResolveReferences() of a missing secret did not fail")
+	}
+}